@@ -0,0 +1,358 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AccessRequestStatus is the state of an access request.
+type AccessRequestStatus string
+
+// Access request statuses.
+const (
+	AccessRequestPending   AccessRequestStatus = "pending"
+	AccessRequestApproved  AccessRequestStatus = "approved"
+	AccessRequestRejected  AccessRequestStatus = "rejected"
+	AccessRequestCancelled AccessRequestStatus = "cancelled"
+)
+
+// AccessRequestFilter narrows ListAccessRequests. Unset fields match all.
+type AccessRequestFilter struct {
+	RequesterID *uint
+	ApproverID  *uint // Requests this employee may decide
+	Status      *AccessRequestStatus
+}
+
+// RequestRole asks for a role for the given duration. The role's owner or
+// the manager of the role's department must approve it.
+func (r *RBAC) RequestRole(requesterID, roleID uint, reason string, duration time.Duration) (*AccessRequest, error) {
+	if requesterID == 0 || roleID == 0 || reason == "" || duration <= 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var role Role
+	if err := r.db.First(&role, roleID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	req := &AccessRequest{
+		RequesterID:  requesterID,
+		RoleID:       &roleID,
+		DepartmentID: &role.DepartmentID,
+		Reason:       reason,
+		Duration:     duration,
+		Status:       AccessRequestPending,
+	}
+	if err := r.db.Create(req).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(requesterID, "request_access", "access_request", req.ID,
+		fmt.Sprintf("Requested role %s for %s: %s", role.Name, duration, reason))
+	return req, nil
+}
+
+// RequestPermission asks for a permission within a department for the given
+// duration. The department's manager must approve it.
+func (r *RBAC) RequestPermission(requesterID, permID, deptID uint, reason string, duration time.Duration) (*AccessRequest, error) {
+	if requesterID == 0 || permID == 0 || deptID == 0 || reason == "" || duration <= 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var perm Permission
+	if err := r.db.First(&perm, permID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	var dept Department
+	if err := r.db.First(&dept, deptID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	req := &AccessRequest{
+		RequesterID:  requesterID,
+		PermissionID: &permID,
+		DepartmentID: &deptID,
+		Reason:       reason,
+		Duration:     duration,
+		Status:       AccessRequestPending,
+	}
+	if err := r.db.Create(req).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(requesterID, "request_access", "access_request", req.ID,
+		fmt.Sprintf("Requested permission %s in department %s for %s: %s", perm.Name, dept.Name, duration, reason))
+	return req, nil
+}
+
+// ApproveAccessRequest approves a pending request and grants the access
+// until the requested duration has passed. Role requests assign the role;
+// permission requests assign a role holding the permission in the
+// department, shared by every request for it. Requesters cannot approve their own requests.
+func (r *RBAC) ApproveAccessRequest(requestID, approverID uint, note string) (*AccessRequest, error) {
+	req, err := r.pendingRequestFor(requestID, approverID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(req.Duration)
+	var roleID uint
+	var roleCreated bool
+	err = r.db.Transaction(func(tx *gorm.DB) (err error) {
+		roleID, roleCreated, err = r.accessRequestRole(tx, req)
+		if err != nil {
+			return err
+		}
+
+		// Only one decision can win if approvers act at the same time
+		res := tx.Model(&AccessRequest{}).
+			Where("id = ? AND status = ?", req.ID, AccessRequestPending).
+			Updates(map[string]interface{}{
+				"status":          AccessRequestApproved,
+				"decided_by":      approverID,
+				"decided_at":      now,
+				"decision_note":   note,
+				"granted_role_id": roleID,
+				"expires_at":      expiresAt,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("%w: access request %d is no longer pending", ErrInvalidInput, req.ID)
+		}
+
+		err = r.grantRoles(tx, map[uint][]uint{req.RequesterID: {roleID}}, func() error {
+			_, err := grantTemporaryRole(tx, req.RequesterID, roleID, expiresAt)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, []uint{req.RequesterID})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.invalidateCache(req.RequesterID)
+	if roleCreated {
+		r.logAudit(approverID, "create_role", "role", roleID,
+			fmt.Sprintf("Created role for access request %d", req.ID))
+	}
+	r.logAudit(approverID, "approve_access_request", "access_request", req.ID,
+		fmt.Sprintf("Approved access for employee %d until %s: %s", req.RequesterID, expiresAt.Format(time.RFC3339), note))
+	return r.GetAccessRequest(req.ID)
+}
+
+// RejectAccessRequest rejects a pending request.
+func (r *RBAC) RejectAccessRequest(requestID, approverID uint, note string) (*AccessRequest, error) {
+	req, err := r.pendingRequestFor(requestID, approverID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.decideAccessRequest(req, AccessRequestRejected, approverID, note); err != nil {
+		return nil, err
+	}
+
+	r.logAudit(approverID, "reject_access_request", "access_request", req.ID,
+		fmt.Sprintf("Rejected access for employee %d: %s", req.RequesterID, note))
+	return r.GetAccessRequest(req.ID)
+}
+
+// CancelAccessRequest withdraws a pending request. Only the requester may
+// cancel it.
+func (r *RBAC) CancelAccessRequest(requestID, requesterID uint) error {
+	if requestID == 0 || requesterID == 0 {
+		return ErrInvalidInput
+	}
+
+	req, err := r.GetAccessRequest(requestID)
+	if err != nil {
+		return err
+	}
+	if req.RequesterID != requesterID {
+		return ErrPermissionDenied
+	}
+	if req.Status != AccessRequestPending {
+		return ErrInvalidInput
+	}
+
+	if err := r.decideAccessRequest(req, AccessRequestCancelled, requesterID, ""); err != nil {
+		return err
+	}
+
+	r.logAudit(requesterID, "cancel_access_request", "access_request", req.ID, "Cancelled access request")
+	return nil
+}
+
+// GetAccessRequest retrieves an access request by ID.
+func (r *RBAC) GetAccessRequest(id uint) (*AccessRequest, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var req AccessRequest
+	if err := r.db.First(&req, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &req, nil
+}
+
+// ListAccessRequests retrieves access requests, newest first.
+func (r *RBAC) ListAccessRequests(filter AccessRequestFilter) ([]AccessRequest, error) {
+	query := r.db.Order("created_at DESC")
+	if filter.RequesterID != nil {
+		query = query.Where("requester_id = ?", *filter.RequesterID)
+	}
+	if filter.Status != nil {
+		query = query.Where("status = ?", *filter.Status)
+	}
+	if filter.ApproverID != nil {
+		approverID := *filter.ApproverID
+		query = query.Where("requester_id <> ?", approverID).
+			Where("role_id IN (?) OR department_id IN (?)",
+				r.db.Model(&Role{}).Select("id").Where("owner_id = ?", approverID),
+				r.db.Model(&Department{}).Select("id").Where("manager_id = ?", approverID))
+	}
+
+	var reqs []AccessRequest
+	if err := query.Find(&reqs).Error; err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+// CanApproveAccessRequest reports whether an employee may decide a request:
+// they must own the requested role or manage its department, and must not
+// be the requester.
+func (r *RBAC) CanApproveAccessRequest(requestID, approverID uint) (bool, error) {
+	if requestID == 0 || approverID == 0 {
+		return false, ErrInvalidInput
+	}
+
+	req, err := r.GetAccessRequest(requestID)
+	if err != nil {
+		return false, err
+	}
+	return r.isApprover(req, approverID)
+}
+
+// isApprover reports whether approverID is a designated approver of req.
+func (r *RBAC) isApprover(req *AccessRequest, approverID uint) (bool, error) {
+	if req.RequesterID == approverID {
+		return false, nil
+	}
+	if req.RoleID != nil {
+		var role Role
+		if err := r.db.First(&role, *req.RoleID).Error; err != nil {
+			return false, ErrNotFound
+		}
+		if role.OwnerID != nil && *role.OwnerID == approverID {
+			return true, nil
+		}
+	}
+	if req.DepartmentID != nil {
+		var dept Department
+		if err := r.db.First(&dept, *req.DepartmentID).Error; err != nil {
+			return false, ErrNotFound
+		}
+		if dept.ManagerID != nil && *dept.ManagerID == approverID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pendingRequestFor loads a pending request that approverID may decide.
+func (r *RBAC) pendingRequestFor(requestID, approverID uint) (*AccessRequest, error) {
+	if requestID == 0 || approverID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	req, err := r.GetAccessRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Status != AccessRequestPending {
+		return nil, ErrInvalidInput
+	}
+
+	ok, err := r.isApprover(req, approverID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		r.logAudit(approverID, "deny_access_decision", "access_request", req.ID, "Not an approver for this request")
+		return nil, ErrPermissionDenied
+	}
+	return req, nil
+}
+
+// decideAccessRequest records a decision that grants nothing.
+func (r *RBAC) decideAccessRequest(req *AccessRequest, status AccessRequestStatus, deciderID uint, note string) error {
+	res := r.db.Model(&AccessRequest{}).
+		Where("id = ? AND status = ?", req.ID, AccessRequestPending).
+		Updates(map[string]interface{}{
+			"status":        status,
+			"decided_by":    deciderID,
+			"decided_at":    time.Now(),
+			"decision_note": note,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%w: access request %d is no longer pending", ErrInvalidInput, req.ID)
+	}
+	return nil
+}
+
+// accessRequestRole returns the role an approved request assigns, and
+// whether it had to be created. Permission requests share one role per
+// permission and department, created on first use and undeleted if it was
+// removed, so approvals do not pile up roles. The permission is locked, so
+// concurrent approvals for it cannot both create the role.
+func (r *RBAC) accessRequestRole(tx *gorm.DB, req *AccessRequest) (uint, bool, error) {
+	if req.RoleID != nil {
+		return *req.RoleID, false, nil
+	}
+
+	var perm Permission
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&perm, *req.PermissionID).Error; err != nil {
+		return 0, false, ErrNotFound
+	}
+	name := "Access request: " + perm.Name
+	var role Role
+	created := false
+	err := tx.Where("department_id = ? AND name = ?", *req.DepartmentID, name).First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		role = Role{Name: name, DepartmentID: *req.DepartmentID}
+		err = createOrRestore(tx, &role, "department_id = ? AND name = ?", role.DepartmentID, name)
+		created = true
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	var grants int64
+	if err := tx.Model(&ScopedPermission{}).
+		Where("role_id = ? AND permission_id = ? AND department_id = ?", role.ID, perm.ID, *req.DepartmentID).
+		Count(&grants).Error; err != nil {
+		return 0, false, err
+	}
+	if grants == 0 {
+		grant := &ScopedPermission{RoleID: role.ID, PermissionID: perm.ID, DepartmentID: req.DepartmentID}
+		if err := tx.Create(grant).Error; err != nil {
+			return 0, false, err
+		}
+	}
+	return role.ID, created, nil
+}
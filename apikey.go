@@ -0,0 +1,295 @@
+package rbac
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// apiKeyPrefix starts the public part of every generated API key.
+const apiKeyPrefix = "rbk_"
+
+// APIKeyPrincipal is the subject an API key authenticated as.
+type APIKeyPrincipal struct {
+	SubjectID uint
+	KeyID     uint
+	Scopes    []string // Permissions the key is limited to; empty means all of the subject's
+}
+
+// Allows reports whether the key's scopes cover a permission. The subject
+// must still hold the permission itself.
+func (p *APIKeyPrincipal) Allows(permName string) bool {
+	return len(p.Scopes) == 0 || containsString(p.Scopes, permName)
+}
+
+// CreateServiceAccount records a non-human subject, such as a batch job or
+// an integration, under the caller's ID. Service accounts hold roles like
+// employees and authenticate with API keys.
+func (r *RBAC) CreateServiceAccount(id uint, name string, deptID *uint) (*Employee, error) {
+	if id == 0 || name == "" {
+		return nil, ErrInvalidInput
+	}
+	if err := r.validateEmployeeRefs(id, deptID, nil); err != nil {
+		return nil, err
+	}
+
+	account := &Employee{
+		ID:           id,
+		Name:         name,
+		Kind:         SubjectService,
+		Status:       EmployeeActive,
+		DepartmentID: deptID,
+		Attributes:   Attributes{},
+	}
+	if err := r.db.Create(account).Error; err != nil {
+		return nil, err
+	}
+
+	r.invalidateCache(id)
+	r.logAudit(0, "create_service_account", "employee", id, "Created service account: "+name)
+	return account, nil
+}
+
+// ListServiceAccounts retrieves all service accounts.
+func (r *RBAC) ListServiceAccounts() ([]Employee, error) {
+	var accounts []Employee
+	if err := r.db.Where("kind = ?", SubjectService).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// CreateAPIKey issues an API key for a subject. The returned plaintext key
+// is not stored and cannot be retrieved again. Scopes, when given, limit the
+// key to those permissions; expiresAt is optional.
+func (r *RBAC) CreateAPIKey(subjectID uint, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	if subjectID == 0 || name == "" {
+		return nil, "", ErrInvalidInput
+	}
+
+	var subject Employee
+	if err := r.db.First(&subject, subjectID).Error; err != nil {
+		return nil, "", ErrNotFound
+	}
+	if len(scopes) > 0 {
+		scopes = uniqueStrings(scopes)
+		var count int64
+		if err := r.db.Model(&Permission{}).Where("name IN ?", scopes).Count(&count).Error; err != nil {
+			return nil, "", err
+		}
+		if int(count) != len(scopes) {
+			return nil, "", ErrNotFound
+		}
+	}
+
+	key := &APIKey{SubjectID: subjectID, Name: name, Scopes: scopes, ExpiresAt: expiresAt}
+	plaintext, err := r.createAPIKey(r.db, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.logAudit(0, "create_api_key", "api_key", key.ID, fmt.Sprintf("Created API key %s (%s) for subject %d", key.Prefix, name, subjectID))
+	return key, plaintext, nil
+}
+
+// RotateAPIKey issues a replacement for an API key with the same subject,
+// name, scopes and expiry. The old key keeps working for gracePeriod, or is
+// revoked at once if gracePeriod is zero.
+func (r *RBAC) RotateAPIKey(keyID uint, gracePeriod time.Duration) (*APIKey, string, error) {
+	if keyID == 0 || gracePeriod < 0 {
+		return nil, "", ErrInvalidInput
+	}
+
+	var old APIKey
+	if err := r.db.First(&old, keyID).Error; err != nil {
+		return nil, "", ErrNotFound
+	}
+	if old.RevokedAt != nil {
+		return nil, "", ErrInvalidInput
+	}
+
+	key := &APIKey{
+		SubjectID:     old.SubjectID,
+		Name:          old.Name,
+		Scopes:        old.Scopes,
+		ExpiresAt:     old.ExpiresAt,
+		RotatedFromID: &old.ID,
+	}
+	var plaintext string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if plaintext, err = r.createAPIKey(tx, key); err != nil {
+			return err
+		}
+		now := time.Now()
+		if gracePeriod == 0 {
+			return tx.Model(&old).Update("revoked_at", now).Error
+		}
+		cutoff := now.Add(gracePeriod)
+		if old.ExpiresAt != nil && old.ExpiresAt.Before(cutoff) {
+			return nil
+		}
+		return tx.Model(&old).Update("expires_at", cutoff).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.logAudit(0, "rotate_api_key", "api_key", key.ID, fmt.Sprintf("Rotated API key %s to %s", old.Prefix, key.Prefix))
+	return key, plaintext, nil
+}
+
+// RevokeAPIKey revokes an API key immediately.
+func (r *RBAC) RevokeAPIKey(keyID uint) error {
+	if keyID == 0 {
+		return ErrInvalidInput
+	}
+
+	var key APIKey
+	if err := r.db.First(&key, keyID).Error; err != nil {
+		return ErrNotFound
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+
+	if err := r.db.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	r.logAudit(0, "revoke_api_key", "api_key", keyID, "Revoked API key "+key.Prefix)
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID.
+func (r *RBAC) GetAPIKey(id uint) (*APIKey, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var key APIKey
+	if err := r.db.First(&key, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &key, nil
+}
+
+// ListAPIKeys retrieves the API keys of a subject, including revoked and
+// expired ones.
+func (r *RBAC) ListAPIKeys(subjectID uint) ([]APIKey, error) {
+	if subjectID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var keys []APIKey
+	if err := r.db.Where("subject_id = ?", subjectID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// AuthenticateAPIKey resolves a plaintext API key to the subject it acts as.
+// Unknown, revoked and expired keys, and keys of suspended or terminated
+// subjects, are rejected with ErrUnauthenticated. Every rejection of a known
+// key is audited; uses are recorded, in LastUsedAt and the audit log, at most
+// once a minute per key.
+func (r *RBAC) AuthenticateAPIKey(plaintext string) (*APIKeyPrincipal, error) {
+	prefix, _, ok := strings.Cut(plaintext, ".")
+	if !ok || !strings.HasPrefix(prefix, apiKeyPrefix) {
+		return nil, ErrUnauthenticated
+	}
+
+	var key APIKey
+	if err := r.db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnauthenticated
+		}
+		return nil, dbError(err)
+	}
+
+	reject := func(reason string) (*APIKeyPrincipal, error) {
+		r.logAudit(key.SubjectID, "reject_api_key", "api_key", key.ID, fmt.Sprintf("Rejected API key %s: %s", key.Prefix, reason))
+		return nil, ErrUnauthenticated
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(plaintext)), []byte(key.Hash)) != 1 {
+		return reject("secret mismatch")
+	}
+	now := time.Now()
+	if key.RevokedAt != nil {
+		return reject("revoked")
+	}
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return reject("expired")
+	}
+	status, err := r.employeeStatus(key.SubjectID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if status != EmployeeActive {
+		return reject("subject is " + string(status))
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= activityInterval {
+		// Only one of several concurrent requests records the use
+		res := r.db.Model(&APIKey{}).
+			Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-activityInterval)).
+			UpdateColumn("last_used_at", now)
+		if res.Error != nil {
+			return nil, dbError(res.Error)
+		}
+		if res.RowsAffected > 0 {
+			r.logAudit(key.SubjectID, "use_api_key", "api_key", key.ID, "Authenticated with API key "+key.Prefix)
+		}
+	}
+	return &APIKeyPrincipal{SubjectID: key.SubjectID, KeyID: key.ID, Scopes: key.Scopes}, nil
+}
+
+// CheckAPIKeyPermission checks a permission for a request authenticated by
+// API key: the key's scopes must cover the permission and the subject must
+// hold it.
+func (r *RBAC) CheckAPIKeyPermission(principal *APIKeyPrincipal, permName string, scope Scope) error {
+	if principal == nil {
+		return ErrInvalidInput
+	}
+	if !principal.Allows(permName) {
+		return ErrPermissionDenied
+	}
+	return r.CheckPermissionInScope(principal.SubjectID, permName, scope)
+}
+
+// createAPIKey generates the secret for key, stores the key and returns the
+// plaintext.
+func (r *RBAC) createAPIKey(tx *gorm.DB, key *APIKey) (string, error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	key.Prefix = apiKeyPrefix + hex.EncodeToString(id)
+	plaintext := key.Prefix + "." + base64.RawURLEncoding.EncodeToString(secret)
+	key.Hash = hashAPIKey(plaintext)
+	if err := tx.Create(key).Error; err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 hash of a plaintext key.
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,327 @@
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArchiveFormat identifies an archive written by Export.
+const ArchiveFormat = "rbac-archive"
+
+// ArchiveVersion is the archive layout understood by Import.
+const ArchiveVersion = 1
+
+// Archive is a portable copy of the RBAC state. Objects are identified by
+// natural keys, as in a Policy, so an archive can be imported into a
+// database with different IDs. Employee IDs are the caller's own and are
+// kept as they are.
+type Archive struct {
+	Format      string              `json:"format"`
+	Version     int                 `json:"version"`
+	ExportedAt  time.Time           `json:"exported_at"`
+	Policy      Policy              `json:"policy"`
+	Assignments []ArchiveAssignment `json:"assignments,omitempty"`
+}
+
+// ArchiveAssignment is a direct role assignment.
+type ArchiveAssignment struct {
+	EmployeeID uint       `json:"employee_id"`
+	Department string     `json:"department"` // The role's department
+	Role       string     `json:"role"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// ExportOptions controls what Export writes.
+type ExportOptions struct {
+	ExcludeAssignments bool // Write only the role model, e.g. to seed another environment
+}
+
+// ImportOptions controls how Import treats existing data.
+type ImportOptions struct {
+	// Overwrite updates existing objects that differ from the archive.
+	// Without it, any difference is a conflict and nothing is imported.
+	Overwrite bool
+}
+
+// ImportConflict is an existing object that differs from the archive.
+type ImportConflict struct {
+	Kind    string // "department", "permission" or "role"
+	Name    string
+	Details string
+}
+
+// ImportReport summarises an import.
+type ImportReport struct {
+	Created     int // Departments, permissions, roles and grants
+	Updated     int // Objects overwritten, with ImportOptions.Overwrite
+	Assignments int // Role assignments created or restored
+	Conflicts   []ImportConflict
+}
+
+// Export writes every department, permission, role, scoped permission and,
+// unless excluded, direct role assignment as a JSON archive. Lapsed
+// assignments are left out. See ExportPolicy for what cannot be exported.
+func (r *RBAC) Export(w io.Writer, opts ExportOptions) error {
+	archive, err := r.buildArchive(opts)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(archive); err != nil {
+		return err
+	}
+
+	r.logAudit(0, "export_archive", "archive", 0,
+		fmt.Sprintf("Exported %d roles and %d assignments", len(archive.Policy.Roles), len(archive.Assignments)))
+	return nil
+}
+
+// buildArchive reads the archive in one transaction, so that it is a
+// consistent view.
+func (r *RBAC) buildArchive(opts ExportOptions) (*Archive, error) {
+	var archive *Archive
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		archive, err = archiveOf(tx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// archiveOf builds the archive from what tx sees.
+func archiveOf(tx *gorm.DB, opts ExportOptions) (*Archive, error) {
+	policy, err := exportPolicy(tx)
+	if err != nil {
+		return nil, err
+	}
+	archive := &Archive{Format: ArchiveFormat, Version: ArchiveVersion, ExportedAt: time.Now().UTC(), Policy: *policy}
+	if opts.ExcludeAssignments {
+		return archive, nil
+	}
+	if archive.Assignments, err = exportAssignments(tx); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// exportAssignments lists live direct assignments by natural key.
+func exportAssignments(tx *gorm.DB) ([]ArchiveAssignment, error) {
+	s, err := loadPolicyState(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var empRoles []EmployeeRole
+	if err := tx.Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("employee_id, role_id").Find(&empRoles).Error; err != nil {
+		return nil, err
+	}
+
+	assignments := make([]ArchiveAssignment, 0, len(empRoles))
+	for _, er := range empRoles {
+		key, ok := s.roleKeys[er.RoleID]
+		if !ok {
+			continue // Role deleted
+		}
+		if _, ok := s.departments[key.Department]; !ok {
+			continue // Role left out of the policy
+		}
+		assignments = append(assignments, ArchiveAssignment{
+			EmployeeID: er.EmployeeID,
+			Department: key.Department,
+			Role:       key.Name,
+			ExpiresAt:  er.ExpiresAt,
+		})
+	}
+	return assignments, nil
+}
+
+// Import reads an archive written by Export and merges it into the database
+// in a single transaction. Objects are matched by natural key and given new
+// IDs; parents and grants are resolved against the matched objects. Objects
+// that exist but differ are reported as conflicts, and fail the import with
+// ErrImportConflict unless opts.Overwrite is set. Objects missing from the
+// archive are never deleted. Assignments that already exist are kept.
+// Imported assignments are checked against role prerequisites, member limits
+// and separation-of-duties constraints like any other, and a violation fails
+// the whole import.
+func (r *RBAC) Import(rd io.Reader, opts ImportOptions) (*ImportReport, error) {
+	var archive Archive
+	dec := json.NewDecoder(rd)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&archive); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	if err := archive.validate(); err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{}
+	var changes []PlanChange
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		planned, err := planChanges(tx, &archive.Policy, PlanOptions{})
+		if err != nil {
+			return err
+		}
+		for _, c := range planned {
+			if c.Action == PlanUpdate {
+				report.Conflicts = append(report.Conflicts, ImportConflict{Kind: c.Kind, Name: c.Name, Details: c.Details})
+			}
+		}
+		if len(report.Conflicts) > 0 && !opts.Overwrite {
+			return ErrImportConflict
+		}
+
+		if changes, err = applyPolicy(tx, &archive.Policy, PlanOptions{}); err != nil {
+			return err
+		}
+		for _, c := range changes {
+			if c.Action == PlanCreate {
+				report.Created++
+			} else {
+				report.Updated++
+			}
+		}
+
+		report.Assignments, err = r.importAssignments(tx, archive.Assignments)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrImportConflict) {
+			return report, err
+		}
+		return nil, err
+	}
+
+	r.invalidateCache(0)
+	r.auditPolicyChanges(0, changes)
+	r.logAudit(0, "import_archive", "archive", 0,
+		fmt.Sprintf("Imported archive exported at %s: %d created, %d updated, %d assignments, %d conflicts",
+			archive.ExportedAt.Format(time.RFC3339), report.Created, report.Updated, report.Assignments, len(report.Conflicts)))
+	return report, nil
+}
+
+// validate checks the archive's format and version, its policy, and that
+// every assignment is to a declared role.
+func (a *Archive) validate() error {
+	if a.Format != ArchiveFormat || a.Version != ArchiveVersion {
+		return fmt.Errorf("%w: unsupported archive %q version %d", ErrInvalidInput, a.Format, a.Version)
+	}
+	if err := a.Policy.Validate(); err != nil {
+		return err
+	}
+	declared := make(map[roleKey]bool, len(a.Policy.Roles))
+	for _, role := range a.Policy.Roles {
+		declared[roleKey{Department: role.Department, Name: role.Name}] = true
+	}
+	for _, assignment := range a.Assignments {
+		key := roleKey{Department: assignment.Department, Name: assignment.Role}
+		if assignment.EmployeeID == 0 || !declared[key] {
+			return fmt.Errorf("%w: assignment of employee %d to undeclared role %s", ErrInvalidInput, assignment.EmployeeID, key)
+		}
+	}
+	return nil
+}
+
+// importAssignments creates or restores the assignments, skipping lapsed
+// ones and ones that already exist, and returns how many it changed. The
+// new assignments are checked as a whole once they are all in place, so one
+// may satisfy another's prerequisite.
+func (r *RBAC) importAssignments(tx *gorm.DB, assignments []ArchiveAssignment) (int, error) {
+	if len(assignments) == 0 {
+		return 0, nil
+	}
+	s, err := loadPolicyState(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	empIDs := make([]uint, 0, len(assignments))
+	for _, a := range assignments {
+		empIDs = append(empIDs, a.EmployeeID)
+	}
+	var held []EmployeeRole
+	if err := tx.Where("employee_id IN ? AND (expires_at IS NULL OR expires_at > ?)", uniqueIDs(empIDs), now).
+		Find(&held).Error; err != nil {
+		return 0, err
+	}
+	exists := make(map[[2]uint]bool, len(held))
+	for _, er := range held {
+		exists[[2]uint{er.EmployeeID, er.RoleID}] = true
+	}
+
+	grants := make(map[uint][]uint)
+	var pending []ArchiveAssignment
+	for _, a := range assignments {
+		roleID := s.roles[roleKey{Department: a.Department, Name: a.Role}].ID
+		if a.ExpiresAt != nil && !a.ExpiresAt.After(now) || exists[[2]uint{a.EmployeeID, roleID}] {
+			continue
+		}
+		exists[[2]uint{a.EmployeeID, roleID}] = true
+		grants[a.EmployeeID] = append(grants[a.EmployeeID], roleID)
+		pending = append(pending, a)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	err = r.grantRoles(tx, grants, func() error {
+		for _, a := range pending {
+			roleID := s.roles[roleKey{Department: a.Department, Name: a.Role}].ID
+			if _, err := putAssignment(tx, a.EmployeeID, roleID, a.ExpiresAt, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	empIDs = empIDs[:0]
+	for empID := range grants {
+		empIDs = append(empIDs, empID)
+	}
+	return len(pending), r.enforceSoD(tx, empIDs)
+}
+
+// putAssignment makes sure an employee holds a role until expiresAt (nil for
+// good), creating the assignment or reviving a deleted or lapsed one. A live
+// assignment keeps its expiry unless replace is set. It reports whether
+// anything changed.
+func putAssignment(tx *gorm.DB, empID, roleID uint, expiresAt *time.Time, replace bool) (bool, error) {
+	var existing EmployeeRole
+	err := tx.Unscoped().Where("employee_id = ? AND role_id = ?", empID, roleID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, tx.Create(&EmployeeRole{EmployeeID: empID, RoleID: roleID, ExpiresAt: expiresAt}).Error
+	}
+	if err != nil {
+		return false, err
+	}
+	live := !existing.DeletedAt.Valid && (existing.ExpiresAt == nil || existing.ExpiresAt.After(time.Now()))
+	if live && (!replace || equalTimePtr(existing.ExpiresAt, expiresAt)) {
+		return false, nil
+	}
+	// The composite key outlives soft deletes and expiry, so revive the row
+	return true, tx.Unscoped().Model(&EmployeeRole{}).
+		Where("employee_id = ? AND role_id = ?", empID, roleID).
+		Updates(map[string]interface{}{"expires_at": expiresAt, "deleted_at": nil}).Error
+}
+
+// equalTimePtr reports whether two optional times are the same instant.
+func equalTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
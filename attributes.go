@@ -0,0 +1,177 @@
+package rbac
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Attributes is a JSON-encoded attribute bag, e.g. {"location": "Berlin", "level": 5}.
+type Attributes map[string]interface{}
+
+// Value implements driver.Valuer.
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (a *Attributes) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*a = Attributes{}
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported attributes type %T", value)
+	}
+	attrs := Attributes{}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &attrs); err != nil {
+			return err
+		}
+	}
+	*a = attrs
+	return nil
+}
+
+// StringList is a JSON-encoded list of strings.
+type StringList []string
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported string list type %T", value)
+	}
+	var list []string
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &list); err != nil {
+			return err
+		}
+	}
+	*l = list
+	return nil
+}
+
+// IDList is a JSON-encoded list of IDs.
+type IDList []uint
+
+// Value implements driver.Valuer.
+func (l IDList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *IDList) Scan(value interface{}) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported ID list type %T", value)
+	}
+	var list []uint
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &list); err != nil {
+			return err
+		}
+	}
+	*l = list
+	return nil
+}
+
+// SetEmployeeAttributes replaces an employee's attribute bag and updates the
+// employee's membership of dynamic groups to match, in one transaction: if
+// the new membership fails, the attributes are not saved either. An active
+// employee record is created if there is none.
+func (r *RBAC) SetEmployeeAttributes(empID uint, attrs Attributes) error {
+	if empID == 0 {
+		return ErrInvalidInput
+	}
+	if attrs == nil {
+		attrs = Attributes{}
+	}
+
+	emp := &Employee{ID: empID, Kind: SubjectEmployee, Status: EmployeeActive}
+	changed := false
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		if err := tx.FirstOrCreate(emp, empID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(emp).Update("attributes", attrs).Error; err != nil {
+			return err
+		}
+		changed, err = r.syncDynamicMembership(tx, empID, attrs)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if changed {
+		r.invalidateCache(empID)
+	}
+
+	r.logAudit(empID, "set_employee_attributes", "employee", empID, "Updated employee attributes")
+	return nil
+}
+
+// GetEmployeeAttributes retrieves an employee's attribute bag.
+func (r *RBAC) GetEmployeeAttributes(empID uint) (Attributes, error) {
+	if empID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var emp Employee
+	if err := r.db.First(&emp, empID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	if emp.Attributes == nil {
+		return Attributes{}, nil
+	}
+	return emp.Attributes, nil
+}
@@ -5,6 +5,12 @@ import (
 
 )
 
+// Audit severities.
+const (
+	SeverityInfo = "info"
+	SeverityHigh = "high" // Also reported to Config.AlertHook
+)
+
 // logAudit creates an audit log entry.
 func (r *RBAC) logAudit(actorEmpID uint, action, targetType string, targetID uint, details string) {
 	audit := &AuditLog{
@@ -12,6 +18,41 @@ func (r *RBAC) logAudit(actorEmpID uint, action, targetType string, targetID uin
 		Action:     action,
 		TargetType: targetType,
 		TargetID:   targetID,
+		Severity:   SeverityInfo,
+		Details:    details,
+		CreatedAt:  time.Now(),
+	}
+	r.db.Create(audit)
+}
+
+// logAlert creates a high-severity audit log entry and passes it to the
+// alert hook, if one is configured.
+func (r *RBAC) logAlert(actorEmpID uint, action, targetType string, targetID uint, details string) {
+	audit := &AuditLog{
+		ActorEmpID: actorEmpID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Severity:   SeverityHigh,
+		Details:    details,
+		CreatedAt:  time.Now(),
+	}
+	r.db.Create(audit)
+	if r.alertHook != nil {
+		r.alertHook(*audit)
+	}
+}
+
+// logImpersonatedAudit creates an audit log entry for an action the actor
+// took while impersonating subjectEmpID.
+func (r *RBAC) logImpersonatedAudit(actorEmpID, subjectEmpID uint, action, targetType string, targetID uint, details string) {
+	audit := &AuditLog{
+		ActorEmpID: actorEmpID,
+		SubjectID:  &subjectEmpID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Severity:   SeverityInfo,
 		Details:    details,
 		CreatedAt:  time.Now(),
 	}
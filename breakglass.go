@@ -0,0 +1,213 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// breakGlassCheckAction is the audit action recorded for each check made
+// under break-glass access.
+const breakGlassCheckAction = "break_glass_check"
+
+// BreakGlassReport lists what happened under a break-glass grant.
+type BreakGlassReport struct {
+	Grant   BreakGlassGrant
+	Events  []AuditLog // Activation, revocation and expiry
+	Checks  []AuditLog // Every permission check made under the grant, oldest first
+	Allowed int
+	Denied  int
+}
+
+// ActivateBreakGlass grants the configured emergency role to an employee for
+// the break-glass window. A justification is mandatory. Activation raises a
+// high-severity audit event, and the role lapses on its own when the window
+// ends. An employee can hold only one active grant at a time.
+func (r *RBAC) ActivateBreakGlass(empID uint, justification string) (*BreakGlassGrant, error) {
+	justification = strings.TrimSpace(justification)
+	if empID == 0 || justification == "" || r.breakGlassRoleID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	status, err := r.employeeStatus(empID)
+	if err != nil {
+		return nil, err
+	}
+	if status != EmployeeActive {
+		return nil, ErrPermissionDenied
+	}
+
+	var role Role
+	if err := r.db.First(&role, r.breakGlassRoleID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	active, err := r.activeBreakGlassGrant(empID)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return nil, fmt.Errorf("%w: break-glass grant %d is already active", ErrInvalidInput, active.ID)
+	}
+
+	grant := &BreakGlassGrant{
+		EmployeeID:    empID,
+		RoleID:        role.ID,
+		Justification: justification,
+		ExpiresAt:     time.Now().Add(r.breakGlassWindow),
+	}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		previous, err := grantTemporaryRole(tx, empID, role.ID, grant.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		grant.PreviousExpiresAt = previous
+		return tx.Create(grant).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.invalidateCache(empID)
+	r.logAlert(empID, "activate_break_glass", "break_glass_grant", grant.ID,
+		fmt.Sprintf("Break-glass role %s granted until %s: %s", role.Name, grant.ExpiresAt.Format(time.RFC3339), justification))
+	return grant, nil
+}
+
+// RevokeBreakGlass ends a break-glass grant before its window is over. The
+// role assignment the grant made is removed, or cut back to its previous
+// expiry if the grant extended an existing one.
+func (r *RBAC) RevokeBreakGlass(grantID uint) error {
+	if grantID == 0 {
+		return ErrInvalidInput
+	}
+
+	var grant BreakGlassGrant
+	if err := r.db.First(&grant, grantID).Error; err != nil {
+		return ErrNotFound
+	}
+	if grant.EndedAt != nil {
+		return nil
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&grant).Update("ended_at", time.Now()).Error; err != nil {
+			return err
+		}
+		// Only what the grant did is undone. An assignment that outlasted the
+		// grant was never touched, and one changed since is no longer the grant's.
+		ours := tx.Unscoped().Where("employee_id = ? AND role_id = ? AND expires_at = ?", grant.EmployeeID, grant.RoleID, grant.ExpiresAt)
+		if grant.PreviousExpiresAt != nil {
+			return ours.Model(&EmployeeRole{}).Update("expires_at", *grant.PreviousExpiresAt).Error
+		}
+		return ours.Delete(&EmployeeRole{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidateCache(grant.EmployeeID)
+	r.logAlert(grant.EmployeeID, "revoke_break_glass", "break_glass_grant", grantID, "Break-glass access revoked")
+	return nil
+}
+
+// SweepExpiredAssignments removes time-bound role assignments whose time is
+// up and closes expired break-glass grants. Expired assignments already have
+// no effect on checks; sweeping keeps the tables tidy. It returns the number
+// of assignments removed and is meant to be run periodically.
+func (r *RBAC) SweepExpiredAssignments() (int, error) {
+	now := time.Now()
+
+	var grants []BreakGlassGrant
+	if err := r.db.Where("ended_at IS NULL AND expires_at <= ?", now).Find(&grants).Error; err != nil {
+		return 0, err
+	}
+	for _, grant := range grants {
+		if err := r.db.Model(&grant).Update("ended_at", grant.ExpiresAt).Error; err != nil {
+			return 0, err
+		}
+		r.logAudit(grant.EmployeeID, "expire_break_glass", "break_glass_grant", grant.ID, "Break-glass access expired")
+	}
+
+	var expired []EmployeeRole
+	if err := r.db.Unscoped().Where("expires_at <= ?", now).Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if err := r.db.Unscoped().Where("expires_at <= ?", now).Delete(&EmployeeRole{}).Error; err != nil {
+		return 0, err
+	}
+
+	empIDs := make([]uint, 0, len(expired))
+	for _, er := range expired {
+		empIDs = append(empIDs, er.EmployeeID)
+	}
+	r.invalidateEmployees(empIDs)
+	return len(expired), nil
+}
+
+// ListBreakGlassGrants retrieves break-glass grants, newest first,
+// optionally only those still in effect.
+func (r *RBAC) ListBreakGlassGrants(activeOnly bool) ([]BreakGlassGrant, error) {
+	var grants []BreakGlassGrant
+	query := r.db.Order("created_at DESC")
+	if activeOnly {
+		query = query.Where("ended_at IS NULL AND expires_at > ?", time.Now())
+	}
+	if err := query.Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// GetBreakGlassReport builds the post-incident report for a grant: its
+// lifecycle events and every permission check made under it.
+func (r *RBAC) GetBreakGlassReport(grantID uint) (*BreakGlassReport, error) {
+	if grantID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var grant BreakGlassGrant
+	if err := r.db.First(&grant, grantID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	var entries []AuditLog
+	if err := r.db.Where("target_type = ? AND target_id = ?", "break_glass_grant", grantID).
+		Order("created_at, id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	report := &BreakGlassReport{Grant: grant}
+	for _, entry := range entries {
+		if entry.Action != breakGlassCheckAction {
+			report.Events = append(report.Events, entry)
+			continue
+		}
+		report.Checks = append(report.Checks, entry)
+		if strings.HasPrefix(entry.Details, "allowed ") {
+			report.Allowed++
+		} else {
+			report.Denied++
+		}
+	}
+	return report, nil
+}
+
+// activeBreakGlassGrant returns the employee's grant in effect now, if any.
+func (r *RBAC) activeBreakGlassGrant(empID uint) (*BreakGlassGrant, error) {
+	var grant BreakGlassGrant
+	err := r.db.Where("employee_id = ? AND ended_at IS NULL AND expires_at > ?", empID, time.Now()).First(&grant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
@@ -3,7 +3,6 @@ package rbac
 import (
 	"fmt"
 	"sync"
-	"time"
 
 	"gorm.io/gorm"
 )
@@ -12,6 +11,7 @@ import (
 type BulkPermissionResult struct {
 	EmployeeID uint
 	Permission string
+	Resource   *ResourceRef
 	Allowed    bool
 	Error      error
 }
@@ -22,6 +22,7 @@ type BulkEmployeePermission struct {
 	Permission       string
 	DepartmentID     *uint
 	TargetEmployeeID *uint
+	Resource         *ResourceRef
 }
 
 // CheckBulkPermissions checks multiple permissions for multiple employees efficiently
@@ -36,9 +37,8 @@ func (r *RBAC) CheckBulkPermissions(checks []BulkEmployeePermission) []BulkPermi
 
 	// Create channels for work distribution
 	jobs := make(chan int, len(checks))
-	resultsChan := make(chan BulkPermissionResult, len(checks))
 
-	// Start workers
+	// Start workers; each writes only its own result slot
 	var wg sync.WaitGroup
 	for i := 0; i < workerCount; i++ {
 		wg.Add(1)
@@ -46,11 +46,16 @@ func (r *RBAC) CheckBulkPermissions(checks []BulkEmployeePermission) []BulkPermi
 			defer wg.Done()
 			for jobIndex := range jobs {
 				check := checks[jobIndex]
-				err := r.CheckPermission(check.EmployeeID, check.Permission, check.DepartmentID, check.TargetEmployeeID)
+				err := r.CheckPermissionInScope(check.EmployeeID, check.Permission, Scope{
+					DepartmentID:     check.DepartmentID,
+					TargetEmployeeID: check.TargetEmployeeID,
+					Resource:         check.Resource,
+				})
 
-				resultsChan <- BulkPermissionResult{
+				results[jobIndex] = BulkPermissionResult{
 					EmployeeID: check.EmployeeID,
 					Permission: check.Permission,
+					Resource:   check.Resource,
 					Allowed:    err == nil,
 					Error:      err,
 				}
@@ -65,97 +70,196 @@ func (r *RBAC) CheckBulkPermissions(checks []BulkEmployeePermission) []BulkPermi
 	close(jobs)
 
 	// Wait for completion
-	go func() {
-		wg.Wait()
-		close(resultsChan)
-	}()
-
-	// Collect results
-	for result := range resultsChan {
-		for i, check := range checks {
-			if check.EmployeeID == result.EmployeeID && check.Permission == result.Permission {
-				results[i] = result
-				break
-			}
-		}
-	}
+	wg.Wait()
 
 	return results
 }
 
-// BulkAssignRoles assigns multiple roles to multiple employees efficiently
+// BulkAssignRoles assigns multiple roles to multiple employees efficiently.
+// If any assignment violates separation of duties, a prerequisite or a
+// member limit, none are made.
 func (r *RBAC) BulkAssignRoles(assignments map[uint][]uint) error {
 	// Use transaction for consistency
 	return r.db.Transaction(func(tx *gorm.DB) error {
-		for employeeID, roleIDs := range assignments {
-			for _, roleID := range roleIDs {
-				empRole := &EmployeeRole{
-					EmployeeID: employeeID,
-					RoleID:     roleID,
-				}
+		employeeIDs := make([]uint, 0, len(assignments))
+		err := r.grantRoles(tx, assignments, func() error {
+			for employeeID, roleIDs := range assignments {
+				employeeIDs = append(employeeIDs, employeeID)
+				for _, roleID := range roleIDs {
+					empRole := &EmployeeRole{
+						EmployeeID: employeeID,
+						RoleID:     roleID,
+					}
 
-				// Use FirstOrCreate to avoid duplicates
-				if err := tx.Where("employee_id = ? AND role_id = ?", employeeID, roleID).
-					FirstOrCreate(empRole).Error; err != nil {
-					return err
+					// Use FirstOrCreate to avoid duplicates
+					if err := dropDeletedAssignment(tx, employeeID, roleID); err != nil {
+						return err
+					}
+					if err := tx.Where("employee_id = ? AND role_id = ?", employeeID, roleID).
+						FirstOrCreate(empRole).Error; err != nil {
+						return err
+					}
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
-		return nil
+		// All or nothing: one violation rolls back the whole batch
+		return r.enforceSoD(tx, employeeIDs)
 	})
 }
 
-// BulkRemoveRoles removes multiple roles from multiple employees efficiently
+// BulkRemoveRoles removes multiple roles from multiple employees efficiently.
+// Roles that depend on a removed prerequisite follow the PrerequisiteRemoval
+// policy.
 func (r *RBAC) BulkRemoveRoles(removals map[uint][]uint) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		for employeeID, roleIDs := range removals {
-			if err := tx.Where("employee_id = ? AND role_id IN ?", employeeID, roleIDs).
-				Delete(&EmployeeRole{}).Error; err != nil {
-				return err
+	employeeIDs := make([]uint, 0, len(removals))
+	for employeeID := range removals {
+		employeeIDs = append(employeeIDs, employeeID)
+	}
+
+	var cascaded []EmployeeRole
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, employeeIDs, func() error {
+			for employeeID, roleIDs := range removals {
+				if err := tx.Where("employee_id = ? AND role_id IN ?", employeeID, roleIDs).
+					Delete(&EmployeeRole{}).Error; err != nil {
+					return err
+				}
 			}
-		}
-		return nil
+			return nil
+		})
+		return err
 	})
+	if err != nil {
+		return err
+	}
+	r.auditCascade(cascaded)
+	return nil
 }
 
-// GetEmployeePermissionsBulk efficiently retrieves permissions for multiple employees
+// GetEmployeePermissionsBulk efficiently retrieves permissions for multiple
+// employees, without a scope. Like CheckPermission without a department, it
+// restricts no role to its department: roleApplies is still consulted, but
+// passes every role when there is no department to test against. Grants
+// limited to a department, employee or resource are listed as well, so the
+// result says what an employee holds somewhere, not what applies in a given
+// place; use GetEmployeePermissionsInDepartmentBulk or
+// GetEmployeePermissionsForResourceBulk for that.
 func (r *RBAC) GetEmployeePermissionsBulk(employeeIDs []uint) map[uint][]string {
+	results, _ := r.resolveEmployeePermissions(employeeIDs, Scope{})
+	return results
+}
+
+// GetEmployeePermissionsInDepartmentBulk retrieves the permissions multiple
+// employees hold within a department. Only roles in effect there count, and
+// grants inherited from ancestor departments are included.
+func (r *RBAC) GetEmployeePermissionsInDepartmentBulk(employeeIDs []uint, deptID uint) map[uint][]string {
+	results, _ := r.resolveEmployeePermissions(employeeIDs, Scope{DepartmentID: &deptID})
+	return results
+}
+
+// GetEmployeePermissionsForResourceBulk retrieves the permissions multiple
+// employees hold on a resource, including grants on its ancestors.
+func (r *RBAC) GetEmployeePermissionsForResourceBulk(employeeIDs []uint, resource ResourceRef) map[uint][]string {
+	if !resource.valid() {
+		return make(map[uint][]string)
+	}
+	results, _ := r.resolveEmployeePermissions(employeeIDs, Scope{Resource: &resource})
+	return results
+}
+
+// resolveEmployeePermissions is the bulk resolver behind the
+// GetEmployeePermissions*Bulk methods. It follows role inheritance and, when
+// a department or resource is set, the same scope rules as CheckPermission.
+// Global permissions are listed for every requested employee.
+func (r *RBAC) resolveEmployeePermissions(employeeIDs []uint, s Scope) (map[uint][]string, error) {
 	results := make(map[uint][]string)
 
-	// Use a single query to get all employee roles
-	var empRoles []EmployeeRole
-	if err := r.db.Where("employee_id IN ?", employeeIDs).Find(&empRoles).Error; err != nil {
-		return results
+	// Get all employee roles, including those held through groups
+	assignments, err := r.roleAssignments(employeeIDs)
+	if err != nil {
+		return results, err
 	}
 
-	// Group by employee
-	empRoleMap := make(map[uint][]uint)
-	for _, empRole := range empRoles {
-		empRoleMap[empRole.EmployeeID] = append(empRoleMap[empRole.EmployeeID], empRole.RoleID)
+	scope := checkScope{deptID: s.DepartmentID}
+	if s.DepartmentID != nil {
+		ancestors, err := r.GetDepartmentAncestorIDs(*s.DepartmentID)
+		if err != nil {
+			return results, err
+		}
+		scope.deptAncestors = ancestors
+	}
+	if s.Resource != nil {
+		chain, err := r.resourceChain(*s.Resource)
+		if err != nil {
+			return results, err
+		}
+		scope.resourceChain = chain
 	}
 
-	// Get all unique role IDs
-	var allRoleIDs []uint
-	roleIDSet := make(map[uint]bool)
-	for _, roleIDs := range empRoleMap {
+	// Load assigned roles and their ancestors, one level per query
+	roleMap := make(map[uint]Role)
+	var pending []uint
+	for _, roleIDs := range assignments {
+		pending = append(pending, roleIDs...)
+	}
+	for len(pending) > 0 {
+		var roles []Role
+		if err := r.db.Where("id IN ?", pending).Find(&roles).Error; err != nil {
+			return results, err
+		}
+		pending = nil
+		for _, role := range roles {
+			roleMap[role.ID] = role
+			if role.ParentRoleID != nil {
+				if _, seen := roleMap[*role.ParentRoleID]; !seen {
+					pending = append(pending, *role.ParentRoleID)
+				}
+			}
+		}
+	}
+
+	// Group roles in effect by employee
+	empRoleMap := make(map[uint][]uint)
+	for employeeID, roleIDs := range assignments {
 		for _, roleID := range roleIDs {
-			if !roleIDSet[roleID] {
-				roleIDSet[roleID] = true
-				allRoleIDs = append(allRoleIDs, roleID)
+			if role, ok := roleMap[roleID]; ok && r.roleApplies(&role, &scope) {
+				empRoleMap[employeeID] = append(empRoleMap[employeeID], roleID)
 			}
 		}
 	}
 
+	var scopedRoleIDs, legacyRoleIDs []uint
+	for roleID, role := range roleMap {
+		if r.legacyRoleScope && role.IsGlobal { // Legacy: global roles ignore grant scopes
+			legacyRoleIDs = append(legacyRoleIDs, roleID)
+		} else {
+			scopedRoleIDs = append(scopedRoleIDs, roleID)
+		}
+	}
+
 	// Get all scoped permissions for these roles
 	var scopedPerms []ScopedPermission
-	if err := r.db.Where("role_id IN ?", allRoleIDs).Find(&scopedPerms).Error; err != nil {
-		return results
+	if err := scopeGrants(r.db.Where("role_id IN ?", scopedRoleIDs), &scope).Find(&scopedPerms).Error; err != nil {
+		return results, err
+	}
+	if len(legacyRoleIDs) > 0 {
+		var unscoped []ScopedPermission
+		if err := r.db.Where("role_id IN ?", legacyRoleIDs).Find(&unscoped).Error; err != nil {
+			return results, err
+		}
+		scopedPerms = append(scopedPerms, unscoped...)
 	}
 
 	// Get permission names
 	var permIDs []uint
 	permIDSet := make(map[uint]bool)
-	for _, sp := range scopedPerms {
+	roleGrants := make(map[uint][]*ScopedPermission)
+	for i, sp := range scopedPerms {
+		roleGrants[sp.RoleID] = append(roleGrants[sp.RoleID], &scopedPerms[i])
 		if !permIDSet[sp.PermissionID] {
 			permIDSet[sp.PermissionID] = true
 			permIDs = append(permIDs, sp.PermissionID)
@@ -164,7 +268,7 @@ func (r *RBAC) GetEmployeePermissionsBulk(employeeIDs []uint) map[uint][]string
 
 	var perms []Permission
 	if err := r.db.Where("id IN ?", permIDs).Find(&perms).Error; err != nil {
-		return results
+		return results, err
 	}
 
 	// Create permission name map
@@ -173,13 +277,53 @@ func (r *RBAC) GetEmployeePermissionsBulk(employeeIDs []uint) map[uint][]string
 		permNameMap[perm.ID] = perm.Name
 	}
 
+	// Global permissions are held by every employee
+	var globalPerms []string
+	if err := r.db.Model(&Permission{}).Where("is_global = ?", true).Pluck("name", &globalPerms).Error; err != nil {
+		return results, err
+	}
+
+	// Suspended, terminated and deleted employees hold nothing
+	var inactiveIDs []uint
+	if err := r.db.Unscoped().Model(&Employee{}).
+		Where("id IN ? AND (status <> ? OR deleted_at IS NOT NULL)", employeeIDs, EmployeeActive).
+		Pluck("id", &inactiveIDs).Error; err != nil {
+		return results, err
+	}
+	inactive := make(map[uint]bool)
+	for _, id := range inactiveIDs {
+		inactive[id] = true
+	}
+
 	// Build results
-	for employeeID, roleIDs := range empRoleMap {
+	for _, employeeID := range employeeIDs {
+		if inactive[employeeID] {
+			continue
+		}
+		roleIDs := empRoleMap[employeeID]
+		if len(roleIDs) == 0 && len(globalPerms) == 0 {
+			continue
+		}
 		permSet := make(map[string]bool)
+		for _, permName := range globalPerms {
+			permSet[permName] = true
+		}
+		empScope := scope
+		empScope.empID = employeeID
+		empScope.relations = nil
 		for _, roleID := range roleIDs {
-			for _, sp := range scopedPerms {
-				if sp.RoleID == roleID {
-					if permName, exists := permNameMap[sp.PermissionID]; exists {
+			for _, id := range roleChain(roleMap, roleID) {
+				for _, grant := range roleGrants[id] {
+					permName, exists := permNameMap[grant.PermissionID]
+					if !exists || permSet[permName] {
+						continue
+					}
+					// Relative scopes are resolved against each employee
+					ok, err := r.relativeGrantMatches(grant, &empScope)
+					if err != nil {
+						return results, err
+					}
+					if ok {
 						permSet[permName] = true
 					}
 				}
@@ -193,28 +337,25 @@ func (r *RBAC) GetEmployeePermissionsBulk(employeeIDs []uint) map[uint][]string
 		results[employeeID] = permissions
 	}
 
-	return results
+	return results, nil
 }
 
-// CacheBulkPermissions caches permission results for multiple employees
-func (r *RBAC) CacheBulkPermissions(permissions map[string][]uint) error {
-	if r.redis == nil {
-		return nil
-	}
-
-	// Use pipeline for better performance
-	pipe := r.redis.Pipeline()
-
-	for permName, employeeIDs := range permissions {
-		for _, empID := range employeeIDs {
-			key := r.getCacheKey(empID, permName, nil, nil)
-			// Cache as allowed (you might want to check actual permissions first)
-			pipe.Set(r.ctx, key, "true", 30*time.Minute)
+// roleChain returns a role followed by its ancestors, as found in roleMap.
+func roleChain(roleMap map[uint]Role, roleID uint) []uint {
+	var chain []uint
+	visited := make(map[uint]bool)
+	for {
+		role, ok := roleMap[roleID]
+		if !ok || visited[roleID] {
+			return chain
 		}
+		visited[roleID] = true
+		chain = append(chain, roleID)
+		if role.ParentRoleID == nil {
+			return chain
+		}
+		roleID = *role.ParentRoleID
 	}
-
-	_, err := pipe.Exec(r.ctx)
-	return err
 }
 
 // InvalidateBulkCache invalidates cache for multiple employees
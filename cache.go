@@ -2,31 +2,43 @@ package rbac
 
 import (
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-// getCacheKey generates a Redis cache key for permission checks.
-func (r *RBAC) getCacheKey(empID uint, permName string, deptID, targetEmpID *uint) string {
+// getCacheKey generates a Redis cache key for permission checks. Each scope
+// part is tagged so that different scopes never share a key:
+//
+//	<app>:perm:<emp>:<perm>[:d<dept>][:e<target>][:r<type>/<id>]
+func (r *RBAC) getCacheKey(empID uint, permName string, scope Scope) string {
 	key := fmt.Sprintf("%s:perm:%d:%s", r.appName, empID, permName)
-	if deptID != nil {
-		key += fmt.Sprintf(":%d", *deptID)
+	if scope.DepartmentID != nil {
+		key += fmt.Sprintf(":d%d", *scope.DepartmentID)
 	}
-	if targetEmpID != nil {
-		key += fmt.Sprintf(":%d", *targetEmpID)
+	if scope.TargetEmployeeID != nil {
+		key += fmt.Sprintf(":e%d", *scope.TargetEmployeeID)
+	}
+	if scope.Resource != nil {
+		key += ":r" + scope.Resource.String()
 	}
 	return key
 }
 
 // checkCache checks if a permission result is cached.
-func (r *RBAC) checkCache(empID uint, permName string, deptID, targetEmpID *uint) (bool, error) {
+func (r *RBAC) checkCache(empID uint, permName string, scope Scope) (bool, error) {
 	if r.redis == nil {
 		return false, nil
 	}
 
-	key := r.getCacheKey(empID, permName, deptID, targetEmpID)
-	val, err := r.redis.Get(r.ctx, key).Result()
+	key := r.getCacheKey(empID, permName, scope)
+	var val string
+	err := r.withRedis(func() (err error) {
+		val, err = r.redis.Get(r.ctx, key).Result()
+		return err
+	})
 	if err == redis.Nil {
 		return false, nil
 	}
@@ -37,26 +49,46 @@ func (r *RBAC) checkCache(empID uint, permName string, deptID, targetEmpID *uint
 }
 
 // setCache caches a permission check result.
-func (r *RBAC) setCache(empID uint, permName string, deptID, targetEmpID *uint, allowed bool) error {
+// A non-zero until caps the entry's lifetime, for decisions that depend on
+// time-bound role assignments.
+func (r *RBAC) setCache(empID uint, permName string, scope Scope, allowed bool, until time.Time) error {
 	if r.redis == nil {
 		return nil
 	}
 
-	key := r.getCacheKey(empID, permName, deptID, targetEmpID)
-	return r.redis.Set(r.ctx, key, allowed, 24*time.Hour).Err()
+	ttl := 24 * time.Hour
+	if !until.IsZero() {
+		if ttl = time.Until(until); ttl <= 0 {
+			return nil
+		}
+		ttl = min(ttl, 24*time.Hour)
+	}
+
+	key := r.getCacheKey(empID, permName, scope)
+	return r.withRedis(func() error {
+		// Stored as "true"/"false"; go-redis would encode a bool as "1"/"0"
+		return r.redis.Set(r.ctx, key, strconv.FormatBool(allowed), ttl).Err()
+	})
 }
 
 // invalidateCache invalidates cache entries for an employee or all.
 func (r *RBAC) invalidateCache(empID uint) error {
+	prefix := r.appName + ":perm:"
+	if empID != 0 {
+		prefix = fmt.Sprintf("%s:perm:%d:", r.appName, empID)
+	}
+	r.stale.forget(prefix)
+
 	if r.redis == nil {
 		return nil
 	}
+	r.invalidateRelations()
 
-	pattern := r.appName + ":perm:*"
-	if empID != 0 {
-		pattern = fmt.Sprintf("%s:perm:%d:*", r.appName, empID)
-	}
-	keys, err := r.redis.Keys(r.ctx, pattern).Result()
+	var keys []string
+	err := r.withRedis(func() (err error) {
+		keys, err = r.redis.Keys(r.ctx, prefix+"*").Result()
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -69,8 +101,10 @@ func (r *RBAC) invalidateCache(empID uint) error {
 // GetCacheStats returns cache statistics
 func (r *RBAC) GetCacheStats() map[string]interface{} {
 	stats := map[string]interface{}{
-		"app_name":      r.appName,
-		"redis_enabled": r.redis != nil,
+		"app_name":             r.appName,
+		"redis_enabled":        r.redis != nil,
+		"redis_breaker_open":   r.breaker.isOpen(),
+		"stale_serving_window": r.staleTTL.String(),
 	}
 
 	if r.redis != nil {
@@ -108,24 +142,211 @@ func (r *RBAC) ClearAllCache() error {
 	return nil
 }
 
-// WarmCache preloads frequently accessed data into cache
-func (r *RBAC) WarmCache() error {
+// WarmOptions controls which decisions WarmCache precomputes.
+type WarmOptions struct {
+	EmployeeIDs []uint             // Employees to warm; empty means recently active employees
+	ActiveSince time.Duration      // Activity window when EmployeeIDs is empty (default 24h)
+	Permissions []string           // Permissions to warm; empty means all permissions
+	Concurrency int                // Number of workers (default 10)
+	Progress    func(WarmProgress) // Optional; called after each decision
+}
+
+// WarmProgress reports how far cache warming has got.
+type WarmProgress struct {
+	Done  int
+	Total int
+}
+
+// WarmError records a decision that could not be computed.
+type WarmError struct {
+	EmployeeID uint
+	Permission string
+	Err        error
+}
+
+// WarmReport summarises a cache warming run.
+type WarmReport struct {
+	Employees int
+	Decisions int
+	Allowed   int
+	Errors    []WarmError
+	Duration  time.Duration
+}
+
+// activityInterval is how often an employee's activity is written to Redis,
+// and an API key's use to the database. Uses in between are not recorded;
+// the activity window is hours long, so a minute's lag does not matter.
+const activityInterval = time.Minute
+
+// activityLog remembers when each employee's activity was last recorded, so
+// that checks do not write to Redis every time.
+type activityLog struct {
+	mu      sync.Mutex
+	touched map[uint]time.Time
+}
+
+func newActivityLog() *activityLog {
+	return &activityLog{touched: make(map[uint]time.Time)}
+}
+
+// due reports whether the employee's activity should be recorded now, and if
+// so notes it as recorded. Entries older than the interval are pruned every
+// so often.
+func (l *activityLog) due(empID uint, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if last, ok := l.touched[empID]; ok && now.Sub(last) < activityInterval {
+		return false
+	}
+	if len(l.touched) > 0 && len(l.touched)%1024 == 0 {
+		for id, at := range l.touched {
+			if now.Sub(at) >= activityInterval {
+				delete(l.touched, id)
+			}
+		}
+	}
+	l.touched[empID] = now
+	return true
+}
+
+// touchActivity records that an employee has just been checked, so that
+// WarmCache can find recently active employees. Each employee is written at
+// most once per activityInterval.
+func (r *RBAC) touchActivity(empID uint) {
 	if r.redis == nil {
-		return nil
+		return
+	}
+	now := time.Now()
+	if !r.activity.due(empID, now) {
+		return
+	}
+	r.withRedis(func() error {
+		return r.redis.ZAdd(r.ctx, r.appName+":active", redis.Z{
+			Score:  float64(now.Unix()),
+			Member: empID,
+		}).Err()
+	})
+}
+
+// recentlyActiveEmployees returns employees checked within the window.
+func (r *RBAC) recentlyActiveEmployees(since time.Duration) ([]uint, error) {
+	if r.redis == nil {
+		return nil, nil
 	}
 
-	// Cache all permissions
-	var perms []Permission
-	if err := r.db.Find(&perms).Error; err != nil {
+	key := r.appName + ":active"
+	cutoff := time.Now().Add(-since).Unix()
+	var members []string
+	err := r.withRedis(func() (err error) {
+		// Drop entries that fell out of the window while we are here
+		r.redis.ZRemRangeByScore(r.ctx, key, "-inf", fmt.Sprintf("(%d", cutoff))
+		members, err = r.redis.ZRangeByScore(r.ctx, key, &redis.ZRangeBy{
+			Min: fmt.Sprintf("%d", cutoff),
+			Max: "+inf",
+		}).Result()
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	empIDs := make([]uint, 0, len(members))
+	for _, m := range members {
+		var id uint
+		if _, err := fmt.Sscan(m, &id); err == nil && id != 0 {
+			empIDs = append(empIDs, id)
+		}
+	}
+	return empIDs, nil
+}
+
+// WarmCache computes real permission decisions for a set of employees and
+// stores them where CheckPermission looks first. Unscoped decisions are
+// warmed for every requested permission.
+func (r *RBAC) WarmCache(opts WarmOptions) (*WarmReport, error) {
+	start := time.Now()
+	report := &WarmReport{}
+	if r.redis == nil && r.staleTTL <= 0 {
+		return report, nil
 	}
 
-	pipe := r.redis.Pipeline()
-	for _, perm := range perms {
-		key := fmt.Sprintf("%s:permission:%s", r.appName, perm.Name)
-		pipe.Set(r.ctx, key, perm.ID, 1*time.Hour)
+	empIDs := opts.EmployeeIDs
+	if len(empIDs) == 0 {
+		since := opts.ActiveSince
+		if since <= 0 {
+			since = 24 * time.Hour
+		}
+		var err error
+		if empIDs, err = r.recentlyActiveEmployees(since); err != nil {
+			return nil, err
+		}
+	}
+
+	permNames := opts.Permissions
+	if len(permNames) == 0 {
+		if err := r.db.Model(&Permission{}).Pluck("name", &permNames).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	report.Employees = len(empIDs)
+	total := len(empIDs) * len(permNames)
+	if total == 0 {
+		report.Duration = time.Since(start)
+		return report, nil
+	}
+
+	workerCount := opts.Concurrency
+	if workerCount <= 0 {
+		workerCount = 10
+	}
+	if total < workerCount {
+		workerCount = total
+	}
+
+	type warmJob struct {
+		empID    uint
+		permName string
+	}
+	jobs := make(chan warmJob, total)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				decision, err := r.evaluatePermission(job.empID, job.permName, Scope{})
+
+				mu.Lock()
+				report.Decisions++
+				if err != nil {
+					report.Errors = append(report.Errors, WarmError{
+						EmployeeID: job.empID,
+						Permission: job.permName,
+						Err:        err,
+					})
+				} else if decision.Allowed {
+					report.Allowed++
+				}
+				if opts.Progress != nil {
+					opts.Progress(WarmProgress{Done: report.Decisions, Total: total})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, empID := range empIDs {
+		for _, permName := range permNames {
+			jobs <- warmJob{empID: empID, permName: permName}
+		}
 	}
+	close(jobs)
+	wg.Wait()
 
-	_, err := pipe.Exec(r.ctx)
-	return err
+	report.Duration = time.Since(start)
+	return report, nil
 }
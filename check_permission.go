@@ -1,77 +1,383 @@
 package rbac
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scope narrows a permission check. Unset fields do not restrict the check,
+// so a check without a department is covered by department-scoped grants.
+type Scope struct {
+	DepartmentID     *uint
+	TargetEmployeeID *uint
+	Resource         *ResourceRef
+}
+
+// String describes the scope for audit entries and traces.
+func (s Scope) String() string {
+	var parts []string
+	if s.DepartmentID != nil {
+		parts = append(parts, fmt.Sprintf("department %d", *s.DepartmentID))
+	}
+	if s.TargetEmployeeID != nil {
+		parts = append(parts, fmt.Sprintf("employee %d", *s.TargetEmployeeID))
+	}
+	if s.Resource != nil {
+		parts = append(parts, "resource "+s.Resource.String())
+	}
+	if len(parts) == 0 {
+		return "unscoped"
+	}
+	return strings.Join(parts, ", ")
+}
+
 // CheckPermission verifies if an employee has a specific permission.
 func (r *RBAC) CheckPermission(empID uint, permName string, deptID, targetEmpID *uint) error {
-	if empID == 0 || permName == "" {
+	return r.CheckPermissionInScope(empID, permName, Scope{DepartmentID: deptID, TargetEmployeeID: targetEmpID})
+}
+
+// CheckPermissionInScope verifies if an employee has a permission in a scope.
+//
+// Identical checks that are in flight at the same time are coalesced into a
+// single evaluation. If the database is unavailable and stale serving is
+// enabled, the last known decision is returned for up to StaleDecisionTTL.
+func (r *RBAC) CheckPermissionInScope(empID uint, permName string, scope Scope) error {
+	return r.checkPermissionInScope(empID, permName, scope, 0)
+}
+
+// checkPermissionInScope is CheckPermissionInScope on behalf of an actor
+// impersonating empID, or of empID itself when actorID is zero. Audit entries
+// written by the check record both.
+func (r *RBAC) checkPermissionInScope(empID uint, permName string, scope Scope, actorID uint) error {
+	if empID == 0 || permName == "" || !scope.Resource.valid() {
 		return ErrInvalidInput
 	}
+	r.touchActivity(empID)
 
 	// Check cache
-	if allowed, err := r.checkCache(empID, permName, deptID, targetEmpID); err == nil && allowed {
+	if allowed, err := r.checkCache(empID, permName, scope); err == nil && allowed {
 		return nil
 	}
 
-	// Get employee roles
-	var empRoles []EmployeeRole
-	if err := r.db.Where("employee_id = ?", empID).Find(&empRoles).Error; err != nil {
+	key := r.getCacheKey(empID, permName, scope)
+	flightKey := key
+	if actorID != 0 {
+		flightKey = fmt.Sprintf("%s:as:%d", key, actorID) // Audited under the actor, so not shared
+	}
+	v, err, _ := r.flight.Do(flightKey, func() (interface{}, error) {
+		return r.evaluatePermission(empID, permName, scope)
+	})
+	if err != nil {
+		if errors.Is(err, ErrUnavailable) {
+			if allowed, ok := r.serveStale(key, err); ok {
+				if allowed {
+					return nil
+				}
+				return ErrPermissionDenied
+			}
+		}
 		return err
 	}
 
+	// Delegated checks and checks under break-glass access are audited here;
+	// cache warming evaluates without auditing, as nobody made those checks
+	decision := v.(*Decision)
+	if decision.DelegationID != 0 {
+		r.auditCheck(empID, actorID, "use_delegation", "delegation", decision.DelegationID,
+			fmt.Sprintf("Used delegation from employee %d for %s", decision.DelegatorID, permName))
+	}
+	if decision.BreakGlassGrantID != 0 {
+		outcome := "denied"
+		if decision.Allowed {
+			outcome = "allowed"
+		}
+		r.auditCheck(empID, actorID, breakGlassCheckAction, "break_glass_grant", decision.BreakGlassGrantID,
+			fmt.Sprintf("%s %s (%s)", outcome, permName, scope))
+	}
+	if !decision.Allowed {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// auditCheck records an audit entry for a check on empID, under the actor
+// impersonating them when actorID is set.
+func (r *RBAC) auditCheck(empID, actorID uint, action, targetType string, targetID uint, details string) {
+	if actorID != 0 {
+		r.logImpersonatedAudit(actorID, empID, action, targetType, targetID, details)
+	} else {
+		r.logAudit(empID, action, targetType, targetID, details)
+	}
+}
+
+// checkScope carries the scope of a single check through the role walk.
+type checkScope struct {
+	empID         uint
+	deptID        *uint
+	targetEmpID   *uint
+	deptAncestors []uint        // Ancestors of deptID, whose non-exact grants also apply
+	resourceChain []ResourceRef // Checked resource followed by its ancestors
+
+	relations    map[ScopeKind]map[uint]bool // Caller's relative scopes, resolved on demand
+	usedRelative bool                        // Whether a relative grant was evaluated
+}
+
+// evaluatePermission resolves a check against the database and caches the result.
+func (r *RBAC) evaluatePermission(empID uint, permName string, scope Scope) (*Decision, error) {
+	decision, err := r.decide(empID, permName, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decisions that depend on the caller's relations are recomputed from the
+	// cached relation sets instead, since other employees' changes affect them.
+	// Delegated rights depend on the delegator's rights at check time, and
+	// checks under break-glass access are each recorded for the report.
+	if !decision.relative && decision.DelegationID == 0 && decision.BreakGlassGrantID == 0 {
+		r.setCache(empID, permName, scope, decision.Allowed, decision.validUntil)
+	}
+	r.rememberDecision(r.getCacheKey(empID, permName, scope), decision.Allowed)
+	return decision, nil
+}
+
+// decide evaluates a check against the database, recording how the outcome
+// was reached. It does not read or write the cache. Rights the employee does
+// not hold through their own roles may be held through a delegation.
+func (r *RBAC) decide(empID uint, permName string, s Scope) (*Decision, error) {
+	decision, err := r.decideOwn(empID, permName, s, false)
+	if err != nil || decision.Allowed || decision.Reason == ReasonInactiveEmployee {
+		return decision, err
+	}
+	if err := r.applyDelegations(decision, s); err != nil {
+		return nil, err
+	}
+	return decision, nil
+}
+
+// decideOwn evaluates a check against the employee's own roles and global
+// permissions only. When the employee is being evaluated as a delegator, the
+// break-glass role is left out: emergency access is never passed on.
+func (r *RBAC) decideOwn(empID uint, permName string, s Scope, asDelegator bool) (*Decision, error) {
+	decision := &Decision{EmployeeID: empID, Permission: permName}
+
+	// Suspended and terminated employees hold nothing, not even global permissions
+	status, err := r.employeeStatus(empID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	if status != EmployeeActive {
+		decision.Reason = ReasonInactiveEmployee
+		decision.trace("employee %d is %s", empID, status)
+		return decision, nil
+	}
+
+	if r.breakGlassRoleID != 0 && !asDelegator {
+		grant, err := r.activeBreakGlassGrant(empID)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		if grant != nil {
+			decision.BreakGlassGrantID = grant.ID
+			decision.trace("employee %d holds break-glass access until %s (grant %d)",
+				empID, grant.ExpiresAt.Format(time.RFC3339), grant.ID)
+		}
+	}
+
 	// Get permission
 	var perm Permission
 	if err := r.db.Where("name = ?", permName).First(&perm).Error; err != nil {
-		return ErrNotFound
+		return nil, dbError(err)
 	}
 
-	// Check permissions for each role and its parents
-	for _, empRole := range empRoles {
-		if r.checkRolePermission(empRole.RoleID, perm.ID, deptID, targetEmpID) {
-			r.setCache(empID, permName, deptID, targetEmpID, true)
-			return nil
+	// Global permissions are held by every employee, whatever the scope
+	if perm.IsGlobal {
+		decision.Allowed = true
+		decision.Reason = ReasonGlobalPermission
+		decision.trace("permission %q is global", permName)
+		return decision, nil
+	}
+
+	// Get employee roles, including those held through groups
+	assignments, err := r.roleAssignments([]uint{empID})
+	if err != nil {
+		return nil, dbError(err)
+	}
+	roleIDs := assignments[empID]
+	if asDelegator && r.breakGlassRoleID != 0 {
+		kept := roleIDs[:0:0]
+		for _, roleID := range roleIDs {
+			if roleID != r.breakGlassRoleID {
+				kept = append(kept, roleID)
+			}
+		}
+		roleIDs = kept
+	}
+
+	// Cached outcomes must not outlive a time-bound assignment
+	var expiring []EmployeeRole
+	if err := r.db.Where("employee_id = ? AND expires_at > ?", empID, time.Now()).
+		Order("expires_at").Limit(1).Find(&expiring).Error; err != nil {
+		return nil, dbError(err)
+	}
+	if len(expiring) > 0 {
+		decision.validUntil = *expiring[0].ExpiresAt
+	}
+
+	scope, err := r.newCheckScope(empID, s)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { decision.relative = scope.usedRelative }()
+
+	// Check permissions for each role in effect and its parents
+	decision.Reason = ReasonNoMatchingGrant
+	if len(roleIDs) == 0 {
+		decision.Reason = ReasonNoRoles
+	}
+	for _, roleID := range roleIDs {
+		var role Role
+		if err := r.db.First(&role, roleID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return nil, dbError(err)
+		}
+		if !r.roleApplies(&role, scope) {
+			decision.trace("role %d (%s) is not in effect in department %d", role.ID, role.Name, *s.DepartmentID)
+			continue
+		}
+
+		grant, err := r.findRoleGrant(role.ID, perm.ID, scope)
+		if err != nil {
+			return nil, err
+		}
+		if grant != nil {
+			decision.Allowed = true
+			decision.Reason = ReasonRoleGrant
+			decision.AssignedRoleID = role.ID
+			decision.GrantingRoleID = grant.RoleID
+			decision.GrantID = grant.ID
+			decision.trace("role %d (%s) matched grant %d held by role %d", role.ID, role.Name, grant.ID, grant.RoleID)
+			if grant.ScopeKind != "" {
+				decision.trace("grant %d applies relative to the caller (%s)", grant.ID, grant.ScopeKind)
+			}
+			return decision, nil
 		}
+		decision.trace("role %d (%s) has no grant matching the scope", role.ID, role.Name)
 	}
 
-	r.setCache(empID, permName, deptID, targetEmpID, false)
-	return ErrPermissionDenied
+	return decision, nil
 }
 
-// checkRolePermission checks if a role or its parents have the permission.
-func (r *RBAC) checkRolePermission(roleID, permID uint, deptID, targetEmpID *uint) bool {
-	var role Role
-	if err := r.db.First(&role, roleID).Error; err != nil {
-		return false
+// newCheckScope resolves the department ancestors and resource chain of a
+// check's scope.
+func (r *RBAC) newCheckScope(empID uint, s Scope) (*checkScope, error) {
+	scope := &checkScope{empID: empID, deptID: s.DepartmentID, targetEmpID: s.TargetEmployeeID}
+	if s.DepartmentID != nil {
+		ancestors, err := r.GetDepartmentAncestorIDs(*s.DepartmentID)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		scope.deptAncestors = ancestors
 	}
+	if s.Resource != nil {
+		chain, err := r.resourceChain(*s.Resource)
+		if err != nil {
+			return nil, err
+		}
+		scope.resourceChain = chain
+	}
+	return scope, nil
+}
 
-	// Check if role is global
-	if role.IsGlobal {
-		var count int64
-		r.db.Model(&ScopedPermission{}).
-			Where("role_id = ? AND permission_id = ?", roleID, permID).
-			Count(&count)
-		if count > 0 {
+// roleApplies reports whether an assigned role is in effect for a check.
+// Global roles apply everywhere. Other roles apply only within their own
+// department and its descendants; checks without a department are not
+// restricted. Roles inherited through ParentRoleID follow the assigned role.
+func (r *RBAC) roleApplies(role *Role, scope *checkScope) bool {
+	if r.legacyRoleScope || role.IsGlobal || scope.deptID == nil {
+		return true
+	}
+	if role.DepartmentID == *scope.deptID {
+		return true
+	}
+	for _, ancestorID := range scope.deptAncestors {
+		if ancestorID == role.DepartmentID {
 			return true
 		}
 	}
+	return false
+}
 
-	// Check direct scoped permission
-	var count int64
-	query := r.db.Model(&ScopedPermission{}).
-		Where("role_id = ? AND permission_id = ?", roleID, permID)
-	if deptID != nil {
-		query = query.Where("department_id = ? OR department_id IS NULL", *deptID)
+// scopeGrants narrows a ScopedPermission query to grants that cover the scope.
+// A department grant covers its descendant departments unless it is exact.
+func scopeGrants(query *gorm.DB, scope *checkScope) *gorm.DB {
+	if scope.deptID != nil {
+		if len(scope.deptAncestors) > 0 {
+			query = query.Where("department_id IS NULL OR department_id = ? OR (department_id IN ? AND exact_department = ?)",
+				*scope.deptID, scope.deptAncestors, false)
+		} else {
+			query = query.Where("department_id = ? OR department_id IS NULL", *scope.deptID)
+		}
 	}
-	if targetEmpID != nil {
-		query = query.Where("employee_id = ? OR employee_id IS NULL", *targetEmpID)
+	if scope.targetEmpID != nil {
+		query = query.Where("employee_id = ? OR employee_id IS NULL", *scope.targetEmpID)
 	}
-	query.Count(&count)
-	if count > 0 {
-		return true
+	if len(scope.resourceChain) > 0 {
+		// A grant on a resource covers its descendants; an empty ID covers every resource of the type
+		conds := []string{"resource_type IS NULL OR resource_type = ''"}
+		var args []interface{}
+		for _, ref := range scope.resourceChain {
+			conds = append(conds, "(resource_type = ? AND (resource_id = ? OR resource_id = '' OR resource_id IS NULL))")
+			args = append(args, ref.Type, ref.ID)
+		}
+		query = query.Where(strings.Join(conds, " OR "), args...)
+	}
+	return query
+}
+
+// findRoleGrant returns the grant through which a role or its parents hold
+// the permission in the scope, or nil if there is none.
+func (r *RBAC) findRoleGrant(roleID, permID uint, scope *checkScope) (*ScopedPermission, error) {
+	var role Role
+	if err := r.db.First(&role, roleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, dbError(err)
+	}
+
+	var grants []ScopedPermission
+	query := r.db.Where("role_id = ? AND permission_id = ?", roleID, permID)
+	if !(r.legacyRoleScope && role.IsGlobal) { // Legacy: global roles ignore grant scopes
+		query = scopeGrants(query, scope)
+	}
+	if err := query.Find(&grants).Error; err != nil {
+		return nil, dbError(err)
+	}
+	// Absolute grants first, so relative scopes are only resolved when needed
+	sort.SliceStable(grants, func(i, j int) bool {
+		return grants[i].ScopeKind == "" && grants[j].ScopeKind != ""
+	})
+	for i := range grants {
+		ok, err := r.relativeGrantMatches(&grants[i], scope)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &grants[i], nil
+		}
 	}
 
 	// Check parent roles recursively
 	if role.ParentRoleID != nil {
-		return r.checkRolePermission(*role.ParentRoleID, permID, deptID, targetEmpID)
+		return r.findRoleGrant(*role.ParentRoleID, permID, scope)
 	}
 
-	return false
+	return nil, nil
 }
@@ -1,5 +1,14 @@
 package rbac
 
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// maxHierarchyDepth bounds recursive hierarchy queries as a guard against cycles.
+const maxHierarchyDepth = 64
+
 // CreateDepartment creates a new department.
 func (r *RBAC) CreateDepartment(name string) (*Department, error) {
 	if name == "" {
@@ -35,6 +44,30 @@ func (r *RBAC) UpdateDepartment(id uint, name string) (*Department, error) {
 	return &dept, nil
 }
 
+// SetDepartmentManager sets or clears the employee who approves access
+// requests for a department.
+func (r *RBAC) SetDepartmentManager(id uint, managerID *uint) error {
+	if id == 0 || (managerID != nil && *managerID == 0) {
+		return ErrInvalidInput
+	}
+
+	var dept Department
+	if err := r.db.First(&dept, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if err := r.db.Model(&dept).Update("manager_id", managerID).Error; err != nil {
+		return err
+	}
+
+	details := "Cleared department manager"
+	if managerID != nil {
+		details = fmt.Sprintf("Set department manager to employee %d", *managerID)
+	}
+	r.logAudit(0, "set_department_manager", "department", id, details)
+	return nil
+}
+
 // GetDepartment retrieves a department by ID.
 func (r *RBAC) GetDepartment(id uint) (*Department, error) {
 	if id == 0 {
@@ -49,7 +82,8 @@ func (r *RBAC) GetDepartment(id uint) (*Department, error) {
 	return &dept, nil
 }
 
-// DeleteDepartment soft-deletes a department by ID.
+// DeleteDepartment soft-deletes a department by ID. Cached decisions are
+// dropped, as the department's subtree no longer inherits through it.
 func (r *RBAC) DeleteDepartment(id uint) error {
 	if id == 0 {
 		return ErrInvalidInput
@@ -64,6 +98,7 @@ func (r *RBAC) DeleteDepartment(id uint) error {
 		return err
 	}
 
+	r.invalidateCache(0) // Descendants no longer inherit through the department
 	r.logAudit(0, "delete_department", "department", id, "Deleted department")
 	return nil
 }
@@ -76,3 +111,163 @@ func (r *RBAC) ListDepartments() ([]Department, error) {
 	}
 	return depts, nil
 }
+
+// RestoreDepartment undeletes a soft-deleted department. It fails with
+// ErrInvalidInput if its parent is still deleted or its name has been
+// taken by another department since.
+func (r *RBAC) RestoreDepartment(id uint) (*Department, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var dept Department
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").First(&dept, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	if dept.ParentDepartmentID != nil {
+		var parent Department
+		if err := r.db.First(&parent, *dept.ParentDepartmentID).Error; err != nil {
+			return nil, fmt.Errorf("%w: parent department %d is deleted", ErrInvalidInput, *dept.ParentDepartmentID)
+		}
+	}
+	var taken int64
+	if err := r.db.Model(&Department{}).Where("name = ?", dept.Name).Count(&taken).Error; err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: department name %q is in use", ErrInvalidInput, dept.Name)
+	}
+
+	if err := r.db.Unscoped().Model(&dept).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	dept.DeletedAt = gorm.DeletedAt{}
+
+	r.invalidateCache(0) // Grants in the department apply again
+	r.logAudit(0, "restore_department", "department", id, "Restored department: "+dept.Name)
+	return &dept, nil
+}
+
+// ListDeletedDepartments retrieves soft-deleted departments, most recently
+// deleted first.
+func (r *RBAC) ListDeletedDepartments() ([]Department, error) {
+	var depts []Department
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&depts).Error; err != nil {
+		return nil, err
+	}
+	return depts, nil
+}
+
+// CreateSubDepartment creates a new department beneath an existing one.
+func (r *RBAC) CreateSubDepartment(name string, parentID uint) (*Department, error) {
+	if name == "" || parentID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var parent Department
+	if err := r.db.First(&parent, parentID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	dept := &Department{Name: name, ParentDepartmentID: &parentID}
+	if err := r.db.Create(dept).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(0, "create_department", "department", dept.ID, fmt.Sprintf("Created department: %s under %d", name, parentID))
+	return dept, nil
+}
+
+// SetParentDepartment moves a department under a new parent, or makes it a
+// root department when parentID is nil. Moves that would create a cycle are
+// rejected with ErrInvalidInput.
+func (r *RBAC) SetParentDepartment(id uint, parentID *uint) (*Department, error) {
+	if id == 0 || (parentID != nil && *parentID == id) {
+		return nil, ErrInvalidInput
+	}
+
+	var dept Department
+	if err := r.db.First(&dept, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	if parentID != nil {
+		var parent Department
+		if err := r.db.First(&parent, *parentID).Error; err != nil {
+			return nil, ErrNotFound
+		}
+
+		// The new parent must not be the department itself or one of its descendants
+		ancestors, err := r.GetDepartmentAncestorIDs(*parentID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ancestorID := range ancestors {
+			if ancestorID == id {
+				return nil, ErrInvalidInput
+			}
+		}
+	}
+
+	if err := r.db.Model(&dept).Update("parent_department_id", parentID).Error; err != nil {
+		return nil, err
+	}
+	dept.ParentDepartmentID = parentID
+
+	r.invalidateCache(0) // Invalidate cache as hierarchy changes affect scope inheritance
+	details := "Made department a root department"
+	if parentID != nil {
+		details = fmt.Sprintf("Moved department under %d", *parentID)
+	}
+	r.logAudit(0, "move_department", "department", id, details)
+	return &dept, nil
+}
+
+// GetDepartmentAncestorIDs returns the IDs of a department's ancestors,
+// nearest first.
+func (r *RBAC) GetDepartmentAncestorIDs(id uint) ([]uint, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var ids []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_department_id, 0 AS depth
+			FROM departments WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT d.id, d.parent_department_id, a.depth + 1
+			FROM departments d JOIN ancestors a ON d.id = a.parent_department_id
+			WHERE d.deleted_at IS NULL AND a.depth < ?
+		)
+		SELECT id FROM ancestors WHERE depth > 0 ORDER BY depth`, id, maxHierarchyDepth).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetDepartmentDescendantIDs returns the IDs of all departments beneath a department.
+func (r *RBAC) GetDepartmentDescendantIDs(id uint) ([]uint, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var ids []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE descendants AS (
+			SELECT id, 0 AS depth
+			FROM departments WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT d.id, s.depth + 1
+			FROM departments d JOIN descendants s ON d.parent_department_id = s.id
+			WHERE d.deleted_at IS NULL AND s.depth < ?
+		)
+		SELECT id FROM descendants WHERE depth > 0 ORDER BY depth, id`, id, maxHierarchyDepth).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,200 @@
+package rbac
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// CreateDynamicGroup creates a group whose members are the employees whose
+// attributes match rule. Membership is materialized immediately and kept up
+// to date as attributes change; roles assigned to the group apply to its
+// members like for any other group.
+func (r *RBAC) CreateDynamicGroup(name, rule string) (*Group, error) {
+	if name == "" || rule == "" {
+		return nil, ErrInvalidInput
+	}
+	parsed, err := ParseRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	// The group is only kept if its membership can be materialized
+	group := &Group{Name: name, Rule: rule}
+	var changed []uint
+	err = r.db.Transaction(func(tx *gorm.DB) (err error) {
+		if err := tx.Create(group).Error; err != nil {
+			return err
+		}
+		changed, err = r.materializeGroup(tx, group.ID, parsed)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) > 0 {
+		r.invalidateEmployees(changed)
+	}
+
+	r.logAudit(0, "create_dynamic_group", "group", group.ID, fmt.Sprintf("Created dynamic group %s with rule: %s", name, rule))
+	return group, nil
+}
+
+// SetGroupRule changes the rule of a dynamic group and recomputes its
+// membership. An empty rule turns the group back into a static group,
+// keeping its current members as ordinary members.
+func (r *RBAC) SetGroupRule(groupID uint, rule string) error {
+	if groupID == 0 {
+		return ErrInvalidInput
+	}
+
+	var group Group
+	if err := r.db.First(&group, groupID).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if rule == "" {
+		if err := r.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&group).Update("rule", "").Error; err != nil {
+				return err
+			}
+			return tx.Model(&GroupMember{}).Where("group_id = ? AND dynamic = ?", groupID, true).
+				Update("dynamic", false).Error
+		}); err != nil {
+			return err
+		}
+		r.logAudit(0, "set_group_rule", "group", groupID, "Converted dynamic group to static group")
+		return nil
+	}
+
+	parsed, err := ParseRule(rule)
+	if err != nil {
+		return err
+	}
+
+	// Nested groups cannot be combined with a rule
+	var nested int64
+	if err := r.db.Model(&GroupMember{}).Where("group_id = ? AND member_group_id IS NOT NULL", groupID).Count(&nested).Error; err != nil {
+		return err
+	}
+	if nested > 0 {
+		return ErrInvalidInput
+	}
+
+	var changed []uint
+	err = r.db.Transaction(func(tx *gorm.DB) (err error) {
+		if err := tx.Model(&group).Update("rule", rule).Error; err != nil {
+			return err
+		}
+		changed, err = r.materializeGroup(tx, groupID, parsed)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		r.invalidateEmployees(changed)
+	}
+
+	r.logAudit(0, "set_group_rule", "group", groupID, "Set group rule: "+rule)
+	return nil
+}
+
+// materializeGroup brings a dynamic group's membership in line with its
+// rule, adding and removing only the members whose match has changed.
+// Members added by hand before the group became dynamic are replaced. It runs
+// inside the caller's transaction and returns the employees whose membership
+// changed, for the caller to invalidate once committed.
+func (r *RBAC) materializeGroup(tx *gorm.DB, groupID uint, rule *Rule) ([]uint, error) {
+	var employees []Employee
+	if err := tx.Select("id", "attributes").Find(&employees).Error; err != nil {
+		return nil, err
+	}
+	want := make(map[uint]bool)
+	for _, emp := range employees {
+		if rule.Match(emp.Attributes) {
+			want[emp.ID] = true
+		}
+	}
+
+	var current []GroupMember
+	if err := tx.Where("group_id = ? AND employee_id IS NOT NULL", groupID).Find(&current).Error; err != nil {
+		return nil, err
+	}
+
+	var changed []uint
+	have := make(map[uint]bool)
+	for _, member := range current {
+		empID := *member.EmployeeID
+		if want[empID] && member.Dynamic {
+			have[empID] = true
+			continue
+		}
+		if err := tx.Delete(&GroupMember{}, member.ID).Error; err != nil {
+			return nil, err
+		}
+		if !want[empID] {
+			changed = append(changed, empID)
+		}
+	}
+	for empID := range want {
+		if have[empID] {
+			continue
+		}
+		id := empID
+		if err := tx.Create(&GroupMember{GroupID: groupID, EmployeeID: &id, Dynamic: true}).Error; err != nil {
+			return nil, err
+		}
+		changed = append(changed, empID)
+	}
+	if err := r.enforceSoD(tx, changed); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// syncDynamicMembership re-evaluates every dynamic group for one employee
+// after their attributes changed. It runs inside the caller's transaction and
+// reports whether the employee's membership changed.
+func (r *RBAC) syncDynamicMembership(tx *gorm.DB, empID uint, attrs Attributes) (bool, error) {
+	var groups []Group
+	if err := tx.Where("rule <> ''").Find(&groups).Error; err != nil {
+		return false, err
+	}
+
+	var current []GroupMember
+	if err := tx.Where("employee_id = ? AND dynamic = ?", empID, true).Find(&current).Error; err != nil {
+		return false, err
+	}
+	memberOf := make(map[uint]GroupMember)
+	for _, member := range current {
+		memberOf[member.GroupID] = member
+	}
+
+	changed := false
+	for _, group := range groups {
+		rule, err := ParseRule(group.Rule)
+		if err != nil {
+			r.logger.Warn("skipping dynamic group with invalid rule", zap.Uint("group_id", group.ID), zap.Error(err))
+			continue
+		}
+		member, isMember := memberOf[group.ID]
+		switch matches := rule.Match(attrs); {
+		case matches && !isMember:
+			if err := tx.Create(&GroupMember{GroupID: group.ID, EmployeeID: &empID, Dynamic: true}).Error; err != nil {
+				return false, err
+			}
+			changed = true
+		case !matches && isMember:
+			if err := tx.Delete(&GroupMember{}, member.ID).Error; err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, r.enforceSoD(tx, []uint{empID})
+}
@@ -1,6 +1,17 @@
 package rbac
 
-// AssignRole creates a new employee-role mapping.
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AssignRole creates a new employee-role mapping. Assignments that would
+// violate a separation-of-duties constraint fail with a *SoDViolationError;
+// a missing prerequisite role fails with ErrMissingPrerequisite, and a role
+// at its member limit with ErrRoleFull.
 func (r *RBAC) AssignRole(empID, roleID uint) error {
 	if empID == 0 || roleID == 0 {
 		return ErrInvalidInput
@@ -13,7 +24,16 @@ func (r *RBAC) AssignRole(empID, roleID uint) error {
 	}
 
 	empRole := &EmployeeRole{EmployeeID: empID, RoleID: roleID}
-	if err := r.db.Create(empRole).Error; err != nil {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := r.grantRoles(tx, map[uint][]uint{empID: {roleID}}, func() error {
+			return createAssignment(tx, empRole)
+		})
+		if err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, []uint{empID})
+	})
+	if err != nil {
 		return err
 	}
 
@@ -39,10 +59,28 @@ func (r *RBAC) UpdateEmployeeRole(empID, oldRoleID, newRoleID uint) error {
 		return ErrNotFound
 	}
 
-	empRole.RoleID = newRoleID
-	if err := r.db.Save(&empRole).Error; err != nil {
+	// The composite primary key changes, so update by the old key
+	var cascaded []EmployeeRole
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, []uint{empID}, func() error {
+			return r.grantRoles(tx, map[uint][]uint{empID: {newRoleID}}, func() error {
+				if err := dropDeletedAssignment(tx, empID, newRoleID); err != nil {
+					return err
+				}
+				return tx.Model(&EmployeeRole{}).
+					Where("employee_id = ? AND role_id = ?", empID, oldRoleID).
+					Update("role_id", newRoleID).Error
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, []uint{empID})
+	})
+	if err != nil {
 		return err
 	}
+	r.auditCascade(cascaded)
 
 	r.invalidateCache(empID)
 	r.logAudit(empID, "update_employee_role", "employee_role", newRoleID, "Updated role assignment")
@@ -63,7 +101,9 @@ func (r *RBAC) GetEmployeeRole(empID, roleID uint) (*EmployeeRole, error) {
 	return &empRole, nil
 }
 
-// DeleteEmployeeRole soft-deletes an employee-role mapping.
+// DeleteEmployeeRole soft-deletes an employee-role mapping. If another of the
+// employee's roles requires it, the configured PrerequisiteRemoval policy
+// applies.
 func (r *RBAC) DeleteEmployeeRole(empID, roleID uint) error {
 	if empID == 0 || roleID == 0 {
 		return ErrInvalidInput
@@ -74,11 +114,19 @@ func (r *RBAC) DeleteEmployeeRole(empID, roleID uint) error {
 		return ErrNotFound
 	}
 
-	if err := r.db.Delete(&empRole).Error; err != nil {
+	var cascaded []EmployeeRole
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, []uint{empID}, func() error {
+			return tx.Delete(&empRole).Error
+		})
+		return err
+	})
+	if err != nil {
 		return err
 	}
 
 	r.invalidateCache(empID)
+	r.auditCascade(cascaded)
 	r.logAudit(empID, "delete_employee_role", "employee_role", roleID, "Removed role from employee")
 	return nil
 }
@@ -95,3 +143,98 @@ func (r *RBAC) ListEmployeeRoles(empID uint) ([]EmployeeRole, error) {
 	}
 	return empRoles, nil
 }
+
+// RestoreEmployeeRole undeletes a soft-deleted role assignment, keeping its
+// original expiry. The role must not be deleted, and the assignment is
+// checked like a new one: it fails on a separation-of-duties violation, a
+// missing prerequisite or a full role.
+func (r *RBAC) RestoreEmployeeRole(empID, roleID uint) (*EmployeeRole, error) {
+	if empID == 0 || roleID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var empRole EmployeeRole
+	if err := r.db.Unscoped().Where("employee_id = ? AND role_id = ? AND deleted_at IS NOT NULL", empID, roleID).
+		First(&empRole).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	var role Role
+	if err := r.db.First(&role, roleID).Error; err != nil {
+		return nil, fmt.Errorf("%w: role %d is deleted", ErrInvalidInput, roleID)
+	}
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := r.grantRoles(tx, map[uint][]uint{empID: {roleID}}, func() error {
+			return tx.Unscoped().Model(&EmployeeRole{}).
+				Where("employee_id = ? AND role_id = ?", empID, roleID).
+				Update("deleted_at", nil).Error
+		})
+		if err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, []uint{empID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	empRole.DeletedAt = gorm.DeletedAt{}
+
+	r.invalidateCache(empID)
+	r.logAudit(empID, "restore_employee_role", "employee_role", roleID, "Restored role assignment: "+role.Name)
+	return &empRole, nil
+}
+
+// ListDeletedEmployeeRoles retrieves soft-deleted role assignments, most
+// recently deleted first, optionally filtered by employee.
+func (r *RBAC) ListDeletedEmployeeRoles(empID *uint) ([]EmployeeRole, error) {
+	var empRoles []EmployeeRole
+	query := r.db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC")
+	if empID != nil {
+		query = query.Where("employee_id = ?", *empID)
+	}
+	if err := query.Find(&empRoles).Error; err != nil {
+		return nil, err
+	}
+	return empRoles, nil
+}
+
+// createAssignment creates a role assignment. A soft-deleted assignment of
+// the same role would block it through the composite primary key, so it is
+// removed first; its period remains in the assignment history.
+func createAssignment(tx *gorm.DB, empRole *EmployeeRole) error {
+	if err := dropDeletedAssignment(tx, empRole.EmployeeID, empRole.RoleID); err != nil {
+		return err
+	}
+	return tx.Create(empRole).Error
+}
+
+// dropDeletedAssignment permanently removes a soft-deleted assignment.
+func dropDeletedAssignment(tx *gorm.DB, empID, roleID uint) error {
+	return tx.Unscoped().Where("employee_id = ? AND role_id = ? AND deleted_at IS NOT NULL", empID, roleID).
+		Delete(&EmployeeRole{}).Error
+}
+
+// grantTemporaryRole assigns a role until expiresAt. An assignment that
+// already lasts longer is left as it is; a shorter or deleted one is renewed.
+// When a live assignment is extended, its previous expiry is returned so the
+// extension can be undone.
+func grantTemporaryRole(tx *gorm.DB, empID, roleID uint, expiresAt time.Time) (*time.Time, error) {
+	var existing EmployeeRole
+	err := tx.Unscoped().Where("employee_id = ? AND role_id = ?", empID, roleID).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, tx.Create(&EmployeeRole{EmployeeID: empID, RoleID: roleID, ExpiresAt: &expiresAt}).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !existing.DeletedAt.Valid && (existing.ExpiresAt == nil || existing.ExpiresAt.After(expiresAt)) {
+		return nil, nil
+	}
+	var previous *time.Time
+	if !existing.DeletedAt.Valid {
+		previous = existing.ExpiresAt
+	}
+	return previous, tx.Unscoped().Model(&EmployeeRole{}).
+		Where("employee_id = ? AND role_id = ?", empID, roleID).
+		Updates(map[string]interface{}{"expires_at": expiresAt, "deleted_at": nil}).Error
+}
@@ -4,7 +4,15 @@ import "errors"
 
 // Custom errors
 var (
-	ErrInvalidInput     = errors.New("invalid input")
-	ErrNotFound         = errors.New("resource not found")
-	ErrPermissionDenied = errors.New("permission denied")
+	ErrInvalidInput        = errors.New("invalid input")
+	ErrNotFound            = errors.New("resource not found")
+	ErrPermissionDenied    = errors.New("permission denied")
+	ErrUnavailable         = errors.New("authorization backend unavailable")
+	ErrUnauthenticated     = errors.New("invalid or expired credentials")
+	ErrSoDViolation        = errors.New("separation of duties violation")
+	ErrMissingPrerequisite = errors.New("prerequisite role not held")
+	ErrRoleFull            = errors.New("role member limit reached")
+	ErrPrerequisiteInUse   = errors.New("role is a prerequisite of another held role")
+	ErrStalePlan           = errors.New("plan is out of date")
+	ErrImportConflict      = errors.New("import conflicts with existing data")
 )
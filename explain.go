@@ -0,0 +1,53 @@
+package rbac
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reasons reported in a Decision.
+const (
+	ReasonGlobalPermission = "global_permission" // Permission is global and held by everyone
+	ReasonRoleGrant        = "role_grant"        // A role in effect holds a matching grant
+	ReasonNoRoles          = "no_roles"          // Employee holds no roles
+	ReasonNoMatchingGrant  = "no_matching_grant" // No role in effect holds a matching grant
+	ReasonInactiveEmployee = "inactive_employee" // Employee is suspended or terminated
+	ReasonDelegation       = "delegation"        // A delegator holding the permission delegated it
+)
+
+// Decision explains the outcome of a permission check.
+type Decision struct {
+	EmployeeID        uint
+	Permission        string
+	Allowed           bool
+	Reason            string   // One of the Reason* constants
+	AssignedRoleID    uint     // Role held by the employee that granted access
+	GrantingRoleID    uint     // Role holding the grant (the assigned role or an ancestor)
+	GrantID           uint     // ScopedPermission that granted access
+	DelegationID      uint     // Delegation through which access was granted
+	DelegatorID       uint     // Employee who delegated the permission
+	BreakGlassGrantID uint     // Break-glass grant active during the check
+	Trace             []string // Steps taken while evaluating the check
+
+	relative   bool      // Outcome depended on the caller's relative scopes
+	validUntil time.Time // Earliest expiry of a time-bound role assignment, if any
+}
+
+// trace appends a step to the decision's trace.
+func (d *Decision) trace(format string, args ...interface{}) {
+	d.Trace = append(d.Trace, fmt.Sprintf(format, args...))
+}
+
+// ExplainPermission evaluates a check like CheckPermission, bypassing the
+// cache, and reports why it was allowed or denied.
+func (r *RBAC) ExplainPermission(empID uint, permName string, deptID, targetEmpID *uint) (*Decision, error) {
+	return r.ExplainPermissionInScope(empID, permName, Scope{DepartmentID: deptID, TargetEmployeeID: targetEmpID})
+}
+
+// ExplainPermissionInScope is ExplainPermission for an arbitrary scope.
+func (r *RBAC) ExplainPermissionInScope(empID uint, permName string, scope Scope) (*Decision, error) {
+	if empID == 0 || permName == "" || !scope.Resource.valid() {
+		return nil, ErrInvalidInput
+	}
+	return r.decide(empID, permName, scope)
+}
@@ -0,0 +1,506 @@
+package rbac
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CreateGroup creates a new employee group.
+func (r *RBAC) CreateGroup(name string) (*Group, error) {
+	if name == "" {
+		return nil, ErrInvalidInput
+	}
+
+	group := &Group{Name: name}
+	if err := r.db.Create(group).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(0, "create_group", "group", group.ID, "Created group: "+name)
+	return group, nil
+}
+
+// UpdateGroup updates a group's name.
+func (r *RBAC) UpdateGroup(id uint, name string) (*Group, error) {
+	if id == 0 || name == "" {
+		return nil, ErrInvalidInput
+	}
+
+	var group Group
+	if err := r.db.First(&group, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	group.Name = name
+	if err := r.db.Save(&group).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(0, "update_group", "group", group.ID, "Updated group name to: "+name)
+	return &group, nil
+}
+
+// GetGroup retrieves a group by ID.
+func (r *RBAC) GetGroup(id uint) (*Group, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var group Group
+	if err := r.db.First(&group, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &group, nil
+}
+
+// DeleteGroup soft-deletes a group together with its memberships and role
+// assignments. Members whose own roles required one of the group's roles
+// follow the PrerequisiteRemoval policy.
+func (r *RBAC) DeleteGroup(id uint) error {
+	if id == 0 {
+		return ErrInvalidInput
+	}
+
+	var group Group
+	if err := r.db.First(&group, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	// Collect affected employees before the memberships disappear
+	affected, err := r.GetGroupEmployeeIDs(id)
+	if err != nil {
+		return err
+	}
+
+	var cascaded []EmployeeRole
+	err = r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, affected, func() error {
+			if err := tx.Where("group_id = ? OR member_group_id = ?", id, id).Delete(&GroupMember{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("group_id = ?", id).Delete(&GroupRole{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&group).Error
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidateEmployees(affected)
+	r.auditCascade(cascaded)
+	r.logAudit(0, "delete_group", "group", id, "Deleted group")
+	return nil
+}
+
+// ListGroups retrieves all groups.
+func (r *RBAC) ListGroups() ([]Group, error) {
+	var groups []Group
+	if err := r.db.Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// AddGroupMember adds an employee to a group. Members of dynamic groups are
+// managed by the group's rule and cannot be added by hand.
+func (r *RBAC) AddGroupMember(groupID, empID uint) error {
+	if groupID == 0 || empID == 0 {
+		return ErrInvalidInput
+	}
+
+	var group Group
+	if err := r.db.First(&group, groupID).Error; err != nil {
+		return ErrNotFound
+	}
+	if group.Rule != "" {
+		return ErrInvalidInput
+	}
+
+	member := &GroupMember{GroupID: groupID, EmployeeID: &empID}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ? AND employee_id = ?", groupID, empID).FirstOrCreate(member).Error; err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, []uint{empID})
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidateCache(empID)
+	r.logAudit(empID, "add_group_member", "group", groupID, "Added employee to group")
+	return nil
+}
+
+// RemoveGroupMember removes an employee from a group. Members of dynamic
+// groups are managed by the group's rule and cannot be removed by hand.
+func (r *RBAC) RemoveGroupMember(groupID, empID uint) error {
+	if groupID == 0 || empID == 0 {
+		return ErrInvalidInput
+	}
+
+	var member GroupMember
+	if err := r.db.Where("group_id = ? AND employee_id = ?", groupID, empID).First(&member).Error; err != nil {
+		return ErrNotFound
+	}
+	if member.Dynamic {
+		return ErrInvalidInput
+	}
+
+	var cascaded []EmployeeRole
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, []uint{empID}, func() error {
+			return tx.Delete(&member).Error
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidateCache(empID)
+	r.auditCascade(cascaded)
+	r.logAudit(empID, "remove_group_member", "group", groupID, "Removed employee from group")
+	return nil
+}
+
+// AddNestedGroup makes every member of memberGroupID a member of groupID.
+// Nesting that would create a cycle, or nesting into a dynamic group, is
+// rejected with ErrInvalidInput.
+func (r *RBAC) AddNestedGroup(groupID, memberGroupID uint) error {
+	if groupID == 0 || memberGroupID == 0 || groupID == memberGroupID {
+		return ErrInvalidInput
+	}
+
+	var group, memberGroup Group
+	if err := r.db.First(&group, groupID).Error; err != nil {
+		return ErrNotFound
+	}
+	if err := r.db.First(&memberGroup, memberGroupID).Error; err != nil {
+		return ErrNotFound
+	}
+	if group.Rule != "" {
+		return ErrInvalidInput
+	}
+
+	// groupID must not already be nested inside memberGroupID
+	nested, err := r.nestedGroupIDs(memberGroupID)
+	if err != nil {
+		return err
+	}
+	for _, id := range nested {
+		if id == groupID {
+			return ErrInvalidInput
+		}
+	}
+
+	affected, err := r.GetGroupEmployeeIDs(memberGroupID)
+	if err != nil {
+		return err
+	}
+
+	member := &GroupMember{GroupID: groupID, MemberGroupID: &memberGroupID}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ? AND member_group_id = ?", groupID, memberGroupID).FirstOrCreate(member).Error; err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, affected)
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidateEmployees(affected)
+	r.logAudit(0, "add_nested_group", "group", groupID, fmt.Sprintf("Nested group %d in group", memberGroupID))
+	return nil
+}
+
+// RemoveNestedGroup removes a nested group from a group.
+func (r *RBAC) RemoveNestedGroup(groupID, memberGroupID uint) error {
+	if groupID == 0 || memberGroupID == 0 {
+		return ErrInvalidInput
+	}
+
+	var member GroupMember
+	if err := r.db.Where("group_id = ? AND member_group_id = ?", groupID, memberGroupID).First(&member).Error; err != nil {
+		return ErrNotFound
+	}
+
+	affected, err := r.GetGroupEmployeeIDs(memberGroupID)
+	if err != nil {
+		return err
+	}
+	var cascaded []EmployeeRole
+	err = r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, affected, func() error {
+			return tx.Delete(&member).Error
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidateEmployees(affected)
+	r.auditCascade(cascaded)
+	r.logAudit(0, "remove_nested_group", "group", groupID, fmt.Sprintf("Removed nested group %d from group", memberGroupID))
+	return nil
+}
+
+// ListGroupMembers retrieves the direct members of a group.
+func (r *RBAC) ListGroupMembers(groupID uint) ([]GroupMember, error) {
+	if groupID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var members []GroupMember
+	if err := r.db.Where("group_id = ?", groupID).Find(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GetGroupEmployeeIDs fetches every employee in a group, including members
+// of nested groups.
+func (r *RBAC) GetGroupEmployeeIDs(groupID uint) ([]uint, error) {
+	if groupID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	groupIDs, err := r.nestedGroupIDs(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var empIDs []uint
+	if err := r.db.Model(&GroupMember{}).
+		Where("group_id IN ? AND employee_id IS NOT NULL", groupIDs).
+		Distinct("employee_id").
+		Pluck("employee_id", &empIDs).Error; err != nil {
+		return nil, err
+	}
+	return empIDs, nil
+}
+
+// ListEmployeeGroups fetches the groups an employee belongs to, directly or
+// through nesting.
+func (r *RBAC) ListEmployeeGroups(empID uint) ([]uint, error) {
+	if empID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var groupIDs []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE member_groups AS (
+			SELECT group_id, 0 AS depth
+			FROM group_members WHERE employee_id = ? AND deleted_at IS NULL
+			UNION
+			SELECT gm.group_id, mg.depth + 1
+			FROM group_members gm JOIN member_groups mg ON gm.member_group_id = mg.group_id
+			WHERE gm.deleted_at IS NULL AND mg.depth < ?
+		)
+		SELECT DISTINCT group_id FROM member_groups`, empID, maxHierarchyDepth).
+		Scan(&groupIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return groupIDs, nil
+}
+
+// AssignGroupRole gives a role to every member of a group.
+func (r *RBAC) AssignGroupRole(groupID, roleID uint) error {
+	if groupID == 0 || roleID == 0 {
+		return ErrInvalidInput
+	}
+
+	var group Group
+	if err := r.db.First(&group, groupID).Error; err != nil {
+		return ErrNotFound
+	}
+	var role Role
+	if err := r.db.First(&role, roleID).Error; err != nil {
+		return ErrNotFound
+	}
+
+	affected, err := r.GetGroupEmployeeIDs(groupID)
+	if err != nil {
+		return err
+	}
+
+	groupRole := &GroupRole{GroupID: groupID, RoleID: roleID}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ? AND role_id = ?", groupID, roleID).FirstOrCreate(groupRole).Error; err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, affected)
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidateEmployees(affected)
+	r.logAudit(0, "assign_group_role", "group_role", roleID, fmt.Sprintf("Assigned role to group %d", groupID))
+	return nil
+}
+
+// RemoveGroupRole removes a role from a group. Members whose own roles
+// required it follow the PrerequisiteRemoval policy.
+func (r *RBAC) RemoveGroupRole(groupID, roleID uint) error {
+	if groupID == 0 || roleID == 0 {
+		return ErrInvalidInput
+	}
+
+	var groupRole GroupRole
+	if err := r.db.Where("group_id = ? AND role_id = ?", groupID, roleID).First(&groupRole).Error; err != nil {
+		return ErrNotFound
+	}
+
+	affected, err := r.GetGroupEmployeeIDs(groupID)
+	if err != nil {
+		return err
+	}
+
+	var cascaded []EmployeeRole
+	err = r.db.Transaction(func(tx *gorm.DB) (err error) {
+		cascaded, err = r.revokeRoles(tx, affected, func() error {
+			return tx.Delete(&groupRole).Error
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	r.invalidateEmployees(affected)
+	r.auditCascade(cascaded)
+	r.logAudit(0, "remove_group_role", "group_role", roleID, fmt.Sprintf("Removed role from group %d", groupID))
+	return nil
+}
+
+// ListGroupRoles retrieves the roles assigned to a group.
+func (r *RBAC) ListGroupRoles(groupID uint) ([]GroupRole, error) {
+	if groupID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var groupRoles []GroupRole
+	if err := r.db.Where("group_id = ?", groupID).Find(&groupRoles).Error; err != nil {
+		return nil, err
+	}
+	return groupRoles, nil
+}
+
+// nestedGroupIDs returns a group and every group nested inside it.
+func (r *RBAC) nestedGroupIDs(groupID uint) ([]uint, error) {
+	var groupIDs []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE nested AS (
+			SELECT CAST(? AS BIGINT) AS id, 0 AS depth
+			UNION
+			SELECT gm.member_group_id, n.depth + 1
+			FROM group_members gm JOIN nested n ON gm.group_id = n.id
+			WHERE gm.member_group_id IS NOT NULL AND gm.deleted_at IS NULL AND n.depth < ?
+		)
+		SELECT DISTINCT id FROM nested`, groupID, maxHierarchyDepth).
+		Scan(&groupIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return groupIDs, nil
+}
+
+// roleAssignments returns the roles each employee holds, directly through
+// EmployeeRole or through (nested) group membership.
+func (r *RBAC) roleAssignments(empIDs []uint) (map[uint][]uint, error) {
+	return roleAssignmentsIn(r.db, empIDs)
+}
+
+// roleAssignmentsIn is roleAssignments against a given connection, such as
+// an open transaction.
+func roleAssignmentsIn(db *gorm.DB, empIDs []uint) (map[uint][]uint, error) {
+	assignments := make(map[uint][]uint)
+	if len(empIDs) == 0 {
+		return assignments, nil
+	}
+
+	var direct []EmployeeRole
+	if err := db.Where("employee_id IN ? AND (expires_at IS NULL OR expires_at > ?)", empIDs, time.Now()).
+		Find(&direct).Error; err != nil {
+		return nil, err
+	}
+	seen := make(map[[2]uint]bool)
+	for _, er := range direct {
+		seen[[2]uint{er.EmployeeID, er.RoleID}] = true
+		assignments[er.EmployeeID] = append(assignments[er.EmployeeID], er.RoleID)
+	}
+
+	var viaGroups []struct {
+		EmployeeID uint
+		RoleID     uint
+	}
+	err := db.Raw(`
+		WITH RECURSIVE member_groups AS (
+			SELECT employee_id, group_id, 0 AS depth
+			FROM group_members WHERE employee_id IN ? AND deleted_at IS NULL
+			UNION
+			SELECT mg.employee_id, gm.group_id, mg.depth + 1
+			FROM group_members gm JOIN member_groups mg ON gm.member_group_id = mg.group_id
+			WHERE gm.deleted_at IS NULL AND mg.depth < ?
+		)
+		SELECT DISTINCT mg.employee_id, gr.role_id
+		FROM member_groups mg JOIN group_roles gr ON gr.group_id = mg.group_id
+		WHERE gr.deleted_at IS NULL`, empIDs, maxHierarchyDepth).
+		Scan(&viaGroups).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range viaGroups {
+		if !seen[[2]uint{a.EmployeeID, a.RoleID}] {
+			seen[[2]uint{a.EmployeeID, a.RoleID}] = true
+			assignments[a.EmployeeID] = append(assignments[a.EmployeeID], a.RoleID)
+		}
+	}
+	return assignments, nil
+}
+
+// employeesWithRoles returns employees holding any of the roles, directly or
+// through group membership.
+func (r *RBAC) employeesWithRoles(roleIDs []uint) ([]uint, error) {
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
+
+	var empIDs []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE role_groups AS (
+			SELECT group_id AS id, 0 AS depth
+			FROM group_roles WHERE role_id IN ? AND deleted_at IS NULL
+			UNION
+			SELECT gm.member_group_id, rg.depth + 1
+			FROM group_members gm JOIN role_groups rg ON gm.group_id = rg.id
+			WHERE gm.member_group_id IS NOT NULL AND gm.deleted_at IS NULL AND rg.depth < ?
+		)
+		SELECT employee_id FROM employee_roles
+		WHERE role_id IN ? AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		UNION
+		SELECT employee_id FROM group_members
+		WHERE group_id IN (SELECT id FROM role_groups) AND employee_id IS NOT NULL AND deleted_at IS NULL`,
+		roleIDs, maxHierarchyDepth, roleIDs, time.Now()).
+		Scan(&empIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return empIDs, nil
+}
+
+// invalidateEmployees drops cached decisions for every listed employee.
+func (r *RBAC) invalidateEmployees(empIDs []uint) {
+	for _, empID := range empIDs {
+		r.stale.forget(fmt.Sprintf("%s:perm:%d:", r.appName, empID))
+	}
+	r.InvalidateBulkCache(empIDs)
+	r.invalidateRelations()
+}
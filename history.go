@@ -0,0 +1,516 @@
+package rbac
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// historyCallback names the GORM callbacks that keep the history tables in
+// step with the tables they track, and historyScopeCallback the ones that
+// note which rows an update or delete may touch before it runs.
+const (
+	historyCallback      = "rbac:history"
+	historyScopeCallback = "rbac:history_scope"
+)
+
+// historyScopeKey is the statement instance setting holding the scope values
+// noted by historyScopeCallback.
+const historyScopeKey = "rbac:history_scope"
+
+// historyBatchSize bounds the scope values synced per statement.
+const historyBatchSize = 1000
+
+// historySpec describes how a table's live rows are recorded in its history
+// table. Each column pair names a history column and the live column it
+// copies. Key columns are never null; value columns may be. The scope pair
+// is a key column that a row keeps for its lifetime; history is synced only
+// for the scope values a change touches.
+type historySpec struct {
+	history string
+	scope   [2]string
+	key     [][2]string
+	values  [][2]string
+}
+
+// historySpecs are the tracked tables, by live table name.
+var historySpecs = map[string]historySpec{
+	"employee_roles": {
+		history: "employee_role_histories",
+		scope:   [2]string{"employee_id", "employee_id"},
+		key:     [][2]string{{"employee_id", "employee_id"}, {"role_id", "role_id"}},
+		values:  [][2]string{{"expires_at", "expires_at"}},
+	},
+	"scoped_permissions": {
+		history: "scoped_permission_histories",
+		scope:   [2]string{"grant_id", "id"},
+		key:     [][2]string{{"grant_id", "id"}, {"role_id", "role_id"}, {"permission_id", "permission_id"}},
+		values: [][2]string{
+			{"department_id", "department_id"}, {"employee_id", "employee_id"}, {"exact_department", "exact_department"},
+			{"scope_kind", "scope_kind"}, {"resource_type", "resource_type"}, {"resource_id", "resource_id"},
+		},
+	},
+	"roles": {
+		history: "role_histories",
+		scope:   [2]string{"role_id", "id"},
+		key:     [][2]string{{"role_id", "id"}},
+		values:  [][2]string{{"parent_role_id", "parent_role_id"}, {"department_id", "department_id"}, {"is_global", "is_global"}},
+	},
+	"group_members": {
+		history: "group_member_histories",
+		scope:   [2]string{"group_id", "group_id"},
+		key:     [][2]string{{"group_id", "group_id"}},
+		values:  [][2]string{{"employee_id", "employee_id"}, {"member_group_id", "member_group_id"}},
+	},
+	"group_roles": {
+		history: "group_role_histories",
+		scope:   [2]string{"group_id", "group_id"},
+		key:     [][2]string{{"group_id", "group_id"}, {"role_id", "role_id"}},
+	},
+}
+
+// registerHistory keeps the history tables up to date from now on, if they
+// exist, and opens history for rows that have none yet. History is written
+// in the same transaction as each change, so it cannot miss one made through
+// this package or through GORM on the same connection.
+func (r *RBAC) registerHistory() error {
+	for _, model := range []interface{}{
+		&EmployeeRoleHistory{}, &ScopedPermissionHistory{}, &RoleHistory{}, &GroupMemberHistory{}, &GroupRoleHistory{},
+	} {
+		if !r.db.Migrator().HasTable(model) {
+			return nil
+		}
+	}
+
+	callbacks := r.db.Callback()
+	if callbacks.Create().Get(historyCallback) == nil {
+		if err := callbacks.Create().After("gorm:create").Register(historyCallback, recordHistory); err != nil {
+			return err
+		}
+		if err := callbacks.Update().After("gorm:setup_reflect_value").Before("gorm:update").
+			Register(historyScopeCallback, noteHistoryScope); err != nil {
+			return err
+		}
+		if err := callbacks.Update().After("gorm:update").Register(historyCallback, recordHistory); err != nil {
+			return err
+		}
+		if err := callbacks.Delete().Before("gorm:delete").Register(historyScopeCallback, noteHistoryScope); err != nil {
+			return err
+		}
+		if err := callbacks.Delete().After("gorm:delete").Register(historyCallback, recordHistory); err != nil {
+			return err
+		}
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for table := range historySpecs {
+			if err := syncHistory(tx, table, nil, time.Now()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// noteHistoryScope is the GORM callback run before every update and delete.
+// It notes the scope values of the rows the statement may touch, from its
+// conditions and the records it carries, since deleted rows cannot be found
+// afterwards. Nothing is noted when they cannot be told, and the whole table
+// is synced instead.
+func noteHistoryScope(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || db.Statement.Table == "" {
+		return
+	}
+	spec, ok := historySpecs[db.Statement.Table]
+	if !ok {
+		return
+	}
+
+	scope, complete := rowScope(db.Statement, spec.scope[1])
+	where, ok := db.Statement.Clauses["WHERE"].Expression.(clause.Where)
+	if ok && len(where.Exprs) > 0 && db.Statement.Schema != nil {
+		var matched []uint
+		model := reflect.New(db.Statement.Schema.ModelType).Interface()
+		err := db.Session(&gorm.Session{NewDB: true}).Unscoped().Model(model).Clauses(where).
+			Distinct(spec.scope[1]).Pluck(spec.scope[1], &matched).Error
+		if err != nil {
+			db.AddError(fmt.Errorf("recording history of %s: %w", db.Statement.Table, err))
+			return
+		}
+		scope, complete = append(scope, matched...), true
+	}
+	if complete {
+		db.InstanceSet(historyScopeKey, append([]uint{}, scope...))
+	}
+}
+
+// recordHistory is the GORM callback run after every create, update and
+// delete. It fails the statement, and so its transaction, if history cannot
+// be written.
+func recordHistory(db *gorm.DB) {
+	if db.Error != nil || db.DryRun || db.Statement.Table == "" {
+		return
+	}
+	spec, ok := historySpecs[db.Statement.Table]
+	if !ok {
+		return
+	}
+
+	var scope []uint
+	if noted, ok := db.InstanceGet(historyScopeKey); ok {
+		if scope = noted.([]uint); len(scope) == 0 {
+			return // The statement could not have touched a row
+		}
+	} else if created, complete := rowScope(db.Statement, spec.scope[1]); complete && len(created) > 0 {
+		scope = created
+	}
+	if err := syncHistory(db.Session(&gorm.Session{NewDB: true}), db.Statement.Table, scope, time.Now()); err != nil {
+		db.AddError(fmt.Errorf("recording history of %s: %w", db.Statement.Table, err))
+	}
+}
+
+// rowScope returns the scope column values of the records a statement
+// carries, and whether every record had one.
+func rowScope(stmt *gorm.Statement, column string) ([]uint, bool) {
+	if stmt.Schema == nil {
+		return nil, false
+	}
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return nil, false
+	}
+
+	var scope []uint
+	add := func(rv reflect.Value) bool {
+		value, zero := field.ValueOf(stmt.Context, reflect.Indirect(rv))
+		id, ok := value.(uint)
+		if zero || !ok {
+			return false
+		}
+		scope = append(scope, id)
+		return true
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		if !add(rv) {
+			return nil, false
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !add(rv.Index(i)) {
+				return nil, false
+			}
+		}
+	default:
+		return nil, false
+	}
+	return scope, true
+}
+
+// syncHistory closes the open history rows of a table that no longer match
+// a live row, and opens rows for live rows that have no open match. It
+// covers only rows with the given scope values, or the whole table when
+// scope is nil.
+func syncHistory(tx *gorm.DB, table string, scope []uint, now time.Time) error {
+	spec := historySpecs[table]
+	var conds []string
+	for _, c := range spec.key {
+		conds = append(conds, fmt.Sprintf("%s.%s = %s.%s", spec.history, c[0], table, c[1]))
+	}
+	for _, c := range spec.values {
+		conds = append(conds, fmt.Sprintf("(%[1]s.%[2]s = %[3]s.%[4]s OR (%[1]s.%[2]s IS NULL AND %[3]s.%[4]s IS NULL))",
+			spec.history, c[0], table, c[1]))
+	}
+	match := strings.Join(conds, " AND ")
+
+	closeSQL := fmt.Sprintf(`UPDATE %[1]s SET valid_to = ? WHERE valid_to IS NULL AND NOT EXISTS (
+		SELECT 1 FROM %[2]s WHERE %[2]s.deleted_at IS NULL AND %[3]s)`, spec.history, table, match)
+
+	var historyCols, liveCols []string
+	for _, c := range append(append([][2]string{}, spec.key...), spec.values...) {
+		historyCols = append(historyCols, c[0])
+		liveCols = append(liveCols, table+"."+c[1])
+	}
+	openSQL := fmt.Sprintf(`INSERT INTO %[1]s (%[2]s, valid_from)
+		SELECT %[3]s, ? FROM %[4]s WHERE %[4]s.deleted_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM %[1]s WHERE %[1]s.valid_to IS NULL AND %[5]s)`,
+		spec.history, strings.Join(historyCols, ", "), strings.Join(liveCols, ", "), table, match)
+
+	if scope == nil {
+		if err := tx.Exec(closeSQL, now).Error; err != nil {
+			return err
+		}
+		return tx.Exec(openSQL, now).Error
+	}
+	closeSQL += fmt.Sprintf(" AND %s.%s IN ?", spec.history, spec.scope[0])
+	openSQL += fmt.Sprintf(" AND %s.%s IN ?", table, spec.scope[1])
+	for start := 0; start < len(scope); start += historyBatchSize {
+		batch := scope[start:min(start+historyBatchSize, len(scope))]
+		if err := tx.Exec(closeSQL, now, batch).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(openSQL, now, batch).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckPermissionAt verifies whether an employee held a permission in a
+// scope at a past time, from the recorded history of role assignments,
+// group memberships, group roles, grants and role parents. Departments,
+// resources, relative scopes and whether the permission is global are
+// resolved as they are now; employee status, delegations and break-glass
+// access are not considered. Times before history was first recorded see no
+// access at all.
+func (r *RBAC) CheckPermissionAt(empID uint, permName string, scope Scope, at time.Time) error {
+	if empID == 0 || permName == "" || at.IsZero() || !scope.Resource.valid() {
+		return ErrInvalidInput
+	}
+
+	state, err := r.loadHistoricalState(permName, scope, at)
+	if err != nil {
+		return err
+	}
+	if state.global {
+		return nil
+	}
+
+	assignments, err := historicalAssignments(r.db, []uint{empID}, at)
+	if err != nil {
+		return dbError(err)
+	}
+	held, err := r.heldAt(state, assignments[empID], state.scopeFor(empID))
+	if err != nil {
+		return err
+	}
+	if !held {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// WhoHadPermission lists, in ascending order, the employees who held a
+// permission in a scope at a past time. It is subject to the same
+// limitations as CheckPermissionAt, and rejects global permissions, which
+// every employee holds.
+func (r *RBAC) WhoHadPermission(permName string, scope Scope, at time.Time) ([]uint, error) {
+	if permName == "" || at.IsZero() || !scope.Resource.valid() {
+		return nil, ErrInvalidInput
+	}
+
+	state, err := r.loadHistoricalState(permName, scope, at)
+	if err != nil {
+		return nil, err
+	}
+	if state.global {
+		return nil, fmt.Errorf("%w: %s is a global permission", ErrInvalidInput, permName)
+	}
+
+	assignments, err := historicalAssignments(r.db, nil, at)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	empIDs := make([]uint, 0)
+	for empID, roleIDs := range assignments {
+		held, err := r.heldAt(state, roleIDs, state.scopeFor(empID))
+		if err != nil {
+			return nil, err
+		}
+		if held {
+			empIDs = append(empIDs, empID)
+		}
+	}
+	sort.Slice(empIDs, func(i, j int) bool { return empIDs[i] < empIDs[j] })
+	return empIDs, nil
+}
+
+// historicalState holds the roles and grants of one permission that were in
+// place at a point in time.
+type historicalState struct {
+	global   bool
+	scope    *checkScope
+	roles    map[uint]Role
+	grants   map[uint][]ScopedPermission // Grants covering the scope, by role
+	unscoped map[uint][]ScopedPermission // All grants, by role; loaded for legacy role scope only
+}
+
+// scopeFor returns the check scope for one employee.
+func (s *historicalState) scopeFor(empID uint) *checkScope {
+	scope := *s.scope
+	scope.empID = empID
+	scope.relations = nil
+	return &scope
+}
+
+// loadHistoricalState loads the roles and the permission's grants in place
+// at a time.
+func (r *RBAC) loadHistoricalState(permName string, s Scope, at time.Time) (*historicalState, error) {
+	// The permission may have been deleted since, and its name reused
+	var perms []Permission
+	if err := r.db.Unscoped().Where("name = ?", permName).Order("deleted_at IS NULL DESC, deleted_at DESC").
+		Find(&perms).Error; err != nil {
+		return nil, dbError(err)
+	}
+	if len(perms) == 0 {
+		return nil, ErrNotFound
+	}
+	if perms[0].IsGlobal {
+		return &historicalState{global: true}, nil
+	}
+	permIDs := make([]uint, 0, len(perms))
+	for _, perm := range perms {
+		permIDs = append(permIDs, perm.ID)
+	}
+
+	scope, err := r.newCheckScope(0, s)
+	if err != nil {
+		return nil, err
+	}
+	state := &historicalState{scope: scope, roles: make(map[uint]Role)}
+
+	var roles []RoleHistory
+	if err := validAt(r.db, at).Find(&roles).Error; err != nil {
+		return nil, dbError(err)
+	}
+	for _, h := range roles {
+		state.roles[h.RoleID] = Role{ID: h.RoleID, DepartmentID: h.DepartmentID, ParentRoleID: h.ParentRoleID, IsGlobal: h.IsGlobal}
+	}
+
+	load := func(query *gorm.DB) (map[uint][]ScopedPermission, error) {
+		var history []ScopedPermissionHistory
+		if err := query.Where("permission_id IN ?", permIDs).Find(&history).Error; err != nil {
+			return nil, dbError(err)
+		}
+		grants := make(map[uint][]ScopedPermission)
+		for _, h := range history {
+			grants[h.RoleID] = append(grants[h.RoleID], h.grant())
+		}
+		// Absolute grants first, so relative scopes are only resolved when needed
+		for _, g := range grants {
+			sort.SliceStable(g, func(i, j int) bool { return g[i].ScopeKind == "" && g[j].ScopeKind != "" })
+		}
+		return grants, nil
+	}
+	if state.grants, err = load(scopeGrants(validAt(r.db, at), scope)); err != nil {
+		return nil, err
+	}
+	if r.legacyRoleScope {
+		if state.unscoped, err = load(validAt(r.db, at)); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// heldAt reports whether any of the roles, or their parents, held a grant
+// matching the scope, following the same rules as a live check.
+func (r *RBAC) heldAt(state *historicalState, roleIDs []uint, scope *checkScope) (bool, error) {
+	for _, roleID := range roleIDs {
+		role, ok := state.roles[roleID]
+		if !ok || !r.roleApplies(&role, scope) {
+			continue
+		}
+		for depth := 0; ok && depth <= maxHierarchyDepth; depth++ {
+			grants := state.grants[role.ID]
+			if r.legacyRoleScope && role.IsGlobal { // Legacy: global roles ignore grant scopes
+				grants = state.unscoped[role.ID]
+			}
+			for i := range grants {
+				matches, err := r.relativeGrantMatches(&grants[i], scope)
+				if err != nil {
+					return false, err
+				}
+				if matches {
+					return true, nil
+				}
+			}
+			if role.ParentRoleID == nil {
+				break
+			}
+			role, ok = state.roles[*role.ParentRoleID]
+		}
+	}
+	return false, nil
+}
+
+// historicalAssignments returns the roles each employee held at a time,
+// directly or through groups. A nil empIDs covers every employee.
+func historicalAssignments(db *gorm.DB, empIDs []uint, at time.Time) (map[uint][]uint, error) {
+	assignments := make(map[uint][]uint)
+
+	var direct []EmployeeRoleHistory
+	query := validAt(db, at).Where("expires_at IS NULL OR expires_at > ?", at)
+	if empIDs != nil {
+		query = query.Where("employee_id IN ?", empIDs)
+	}
+	if err := query.Find(&direct).Error; err != nil {
+		return nil, err
+	}
+	seen := make(map[[2]uint]bool)
+	for _, h := range direct {
+		if !seen[[2]uint{h.EmployeeID, h.RoleID}] {
+			seen[[2]uint{h.EmployeeID, h.RoleID}] = true
+			assignments[h.EmployeeID] = append(assignments[h.EmployeeID], h.RoleID)
+		}
+	}
+
+	employees, args := "employee_id IS NOT NULL", []interface{}{at, at}
+	if empIDs != nil {
+		employees, args = "employee_id IN ?", []interface{}{empIDs, at, at}
+	}
+	args = append(args, at, at, maxHierarchyDepth, at, at)
+	var viaGroups []struct {
+		EmployeeID uint
+		RoleID     uint
+	}
+	err := db.Raw(`
+		WITH RECURSIVE member_groups AS (
+			SELECT employee_id, group_id, 0 AS depth
+			FROM group_member_histories
+			WHERE `+employees+` AND valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)
+			UNION
+			SELECT mg.employee_id, gm.group_id, mg.depth + 1
+			FROM group_member_histories gm JOIN member_groups mg ON gm.member_group_id = mg.group_id
+			WHERE gm.valid_from <= ? AND (gm.valid_to IS NULL OR gm.valid_to > ?) AND mg.depth < ?
+		)
+		SELECT DISTINCT mg.employee_id, gr.role_id
+		FROM member_groups mg JOIN group_role_histories gr ON gr.group_id = mg.group_id
+		WHERE gr.valid_from <= ? AND (gr.valid_to IS NULL OR gr.valid_to > ?)`, args...).
+		Scan(&viaGroups).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range viaGroups {
+		if !seen[[2]uint{a.EmployeeID, a.RoleID}] {
+			seen[[2]uint{a.EmployeeID, a.RoleID}] = true
+			assignments[a.EmployeeID] = append(assignments[a.EmployeeID], a.RoleID)
+		}
+	}
+	return assignments, nil
+}
+
+// validAt narrows a history query to rows in place at a time.
+func validAt(db *gorm.DB, at time.Time) *gorm.DB {
+	return db.Where("valid_from <= ? AND (valid_to IS NULL OR valid_to > ?)", at, at)
+}
+
+// grant returns the grant as it was during the period.
+func (h ScopedPermissionHistory) grant() ScopedPermission {
+	return ScopedPermission{
+		ID:              h.GrantID,
+		RoleID:          h.RoleID,
+		PermissionID:    h.PermissionID,
+		DepartmentID:    h.DepartmentID,
+		EmployeeID:      h.EmployeeID,
+		ExactDepartment: h.ExactDepartment,
+		ScopeKind:       h.ScopeKind,
+		ResourceType:    h.ResourceType,
+		ResourceID:      h.ResourceID,
+	}
+}
@@ -0,0 +1,138 @@
+package rbac
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Fiber locals set by the middleware.
+const (
+	LocalsEmployeeID = "employee_id"  // uint subject ID, set by your auth middleware or APIKeyMiddleware
+	LocalsAPIKey     = "rbac_api_key" // *APIKeyPrincipal, set by APIKeyMiddleware
+	// LocalsImpersonation holds a uint impersonation session ID; the
+	// X-Impersonation-Session header is used when it is not set.
+	LocalsImpersonation = "rbac_impersonation"
+)
+
+// APIKeyMiddleware authenticates requests carrying an API key in the
+// X-API-Key header or as an "Authorization: Bearer" token, storing the
+// subject under LocalsEmployeeID and the principal under LocalsAPIKey.
+// Requests without a key are passed on untouched, so other authentication
+// can run after it; requests with an invalid key are rejected with 401.
+func (r *RBAC) APIKeyMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get("X-API-Key")
+		if key == "" {
+			if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer "+apiKeyPrefix) {
+				key = strings.TrimPrefix(auth, "Bearer ")
+			}
+		}
+		if key == "" {
+			return c.Next()
+		}
+
+		principal, err := r.AuthenticateAPIKey(key)
+		if err != nil {
+			return fiberError(err)
+		}
+		c.Locals(LocalsEmployeeID, principal.SubjectID)
+		c.Locals(LocalsAPIKey, principal)
+		return c.Next()
+	}
+}
+
+// RbacMiddleware protects a route with a permission check against the
+// subject in LocalsEmployeeID. For API key requests the key's scopes must
+// also cover the permission. When the request names an impersonation
+// session started by that subject, the check is made as the impersonated
+// employee through CheckPermissionAs, still limited to the key's scopes.
+func (r *RBAC) RbacMiddleware(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var empID uint
+		switch v := c.Locals(LocalsEmployeeID).(type) {
+		case uint:
+			empID = v
+		case int:
+			if v > 0 {
+				empID = uint(v)
+			}
+		}
+		if empID == 0 {
+			return fiber.ErrUnauthorized
+		}
+
+		principal, ok := c.Locals(LocalsAPIKey).(*APIKeyPrincipal)
+		if !ok || principal.SubjectID != empID {
+			principal = nil
+		}
+
+		sessionID, err := impersonationSessionID(c)
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if sessionID != 0 {
+			if principal != nil && !principal.Allows(permission) {
+				return fiber.ErrForbidden
+			}
+			session, err := r.GetImpersonationSession(sessionID)
+			if err != nil {
+				return fiberError(err)
+			}
+			if session.ActorID != empID {
+				return fiber.ErrForbidden
+			}
+			if err := r.CheckPermissionAs(sessionID, permission, Scope{}); err != nil {
+				return fiberError(err)
+			}
+			return c.Next()
+		}
+
+		if principal != nil {
+			err = r.CheckAPIKeyPermission(principal, permission, Scope{})
+		} else {
+			err = r.CheckPermission(empID, permission, nil, nil)
+		}
+		if err != nil {
+			return fiberError(err)
+		}
+		return c.Next()
+	}
+}
+
+// impersonationSessionID returns the impersonation session named by the
+// request, or zero if there is none.
+func impersonationSessionID(c *fiber.Ctx) (uint, error) {
+	switch v := c.Locals(LocalsImpersonation).(type) {
+	case uint:
+		return v, nil
+	case int:
+		if v > 0 {
+			return uint(v), nil
+		}
+	}
+	header := c.Get("X-Impersonation-Session")
+	if header == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// fiberError maps a library error to an HTTP error.
+func fiberError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return fiber.ErrUnauthorized
+	case errors.Is(err, ErrPermissionDenied), errors.Is(err, ErrNotFound):
+		return fiber.ErrForbidden
+	case errors.Is(err, ErrUnavailable):
+		return fiber.ErrServiceUnavailable
+	}
+	return fiber.ErrInternalServerError
+}
@@ -8,30 +8,36 @@ import (
 
 // Department represents a logical group (e.g., Sales, HR).
 type Department struct {
-	ID        uint   `gorm:"primaryKey"`
-	Name      string `gorm:"unique;not null"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	ID                 uint   `gorm:"primaryKey"`
+	Name               string `gorm:"not null;uniqueIndex:idx_departments_name,where:deleted_at IS NULL"` // Unique among live rows
+	ParentDepartmentID *uint  `gorm:"index"`                                                              // For department hierarchy (Division > Department > Team)
+	ManagerID          *uint  `gorm:"index"`                                                              // Employee who approves access requests for the department
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
 // Role represents a hierarchical position within a department.
 type Role struct {
-	ID           uint   `gorm:"primaryKey"`
-	Name         string `gorm:"not null"`
-	DepartmentID uint   `gorm:"not null;index"`
-	ParentRoleID *uint  `gorm:"index"` // For role inheritance
-	IsGlobal     bool   `gorm:"default:false"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	ID            uint   `gorm:"primaryKey"`
+	Name          string `gorm:"not null"`
+	DepartmentID  uint   `gorm:"not null;index"`
+	ParentRoleID  *uint  `gorm:"index"` // For role inheritance
+	IsGlobal      bool   `gorm:"default:false"`
+	OwnerID       *uint  `gorm:"index"`     // Employee who approves access requests for the role
+	Prerequisites IDList `gorm:"type:text"` // Roles an employee must hold before being assigned this one
+	MaxMembers    int    `gorm:"default:0"` // Cap on direct assignments; zero means unlimited
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
 }
 
 // Permission represents a named access action.
 type Permission struct {
 	ID        uint   `gorm:"primaryKey"`
-	Name      string `gorm:"unique;not null"`
-	IsGlobal  bool   `gorm:"default:false"`
+	Name      string `gorm:"not null;uniqueIndex:idx_permissions_name,where:deleted_at IS NULL"` // Unique among live rows
+	IsGlobal  bool   `gorm:"default:false"`                                                      // Held by every employee, regardless of roles and scope
+	Sensitive bool   `gorm:"default:false"`                                                      // Never available while impersonating
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
@@ -39,25 +45,312 @@ type Permission struct {
 
 // EmployeeRole maps an employee to a role.
 type EmployeeRole struct {
-	EmployeeID uint `gorm:"primaryKey;autoIncrement:false"`
-	RoleID     uint `gorm:"primaryKey;autoIncrement:false"`
+	EmployeeID uint       `gorm:"primaryKey;autoIncrement:false"`
+	RoleID     uint       `gorm:"primaryKey;autoIncrement:false"`
+	ExpiresAt  *time.Time `gorm:"index"` // Assignment lapses at this time; nil for permanent assignments
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 	DeletedAt  gorm.DeletedAt `gorm:"index"`
 }
 
-// ScopedPermission grants a permission to a role with optional scoping.
-type ScopedPermission struct {
-	ID           uint  `gorm:"primaryKey"`
-	RoleID       uint  `gorm:"index;not null"`
-	PermissionID uint  `gorm:"index;not null"`
-	DepartmentID *uint `gorm:"index"` // Optional department scope
-	EmployeeID   *uint `gorm:"index"` // Optional employee scope
+// Group is a named set of employees, and of nested groups, that can hold roles.
+type Group struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"not null"`
+	Rule      string // Attribute rule for dynamic groups; empty for static groups
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// GroupMember adds either an employee or a nested group to a group.
+type GroupMember struct {
+	ID            uint  `gorm:"primaryKey"`
+	GroupID       uint  `gorm:"index;not null"`
+	EmployeeID    *uint `gorm:"index"`         // Member employee
+	MemberGroupID *uint `gorm:"index"`         // Member group, whose members all belong to GroupID
+	Dynamic       bool  `gorm:"default:false"` // Materialized from the group's rule
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+}
+
+// GroupRole assigns a role to every member of a group.
+type GroupRole struct {
+	ID        uint `gorm:"primaryKey"`
+	GroupID   uint `gorm:"index;not null"`
+	RoleID    uint `gorm:"index;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// Employee is a subject that can hold roles: a person or a service account.
+// Employees are identified by the caller's own IDs; an employee without a
+// record is treated as active.
+type Employee struct {
+	ID           uint `gorm:"primaryKey;autoIncrement:false"`
+	Name         string
+	Kind         SubjectKind    `gorm:"not null;default:employee;index"` // Human employee or service account
+	Status       EmployeeStatus `gorm:"not null;default:active;index"`
+	DepartmentID *uint          `gorm:"index"`     // Home department, used for own-department scopes
+	ManagerID    *uint          `gorm:"index"`     // Direct manager
+	Attributes   Attributes     `gorm:"type:text"` // Evaluated by dynamic group rules
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
+}
+
+// APIKey is a hashed credential that authenticates as a subject. Only the
+// prefix and a SHA-256 hash of the key are stored.
+type APIKey struct {
+	ID            uint       `gorm:"primaryKey"`
+	SubjectID     uint       `gorm:"not null;index"` // Employee or service account the key acts as
+	Name          string     `gorm:"not null"`
+	Prefix        string     `gorm:"unique;not null"` // Public part of the key, used for lookup
+	Hash          string     `gorm:"not null"`
+	Scopes        StringList `gorm:"type:text"` // Permissions the key is limited to; empty means all of the subject's
+	ExpiresAt     *time.Time
+	LastUsedAt    *time.Time
+	RevokedAt     *time.Time
+	RotatedFromID *uint `gorm:"index"` // Key this one replaced
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+}
+
+// Delegation lets a delegate exercise some of a delegator's permissions for
+// a limited time. The delegator must hold a permission at check time for the
+// delegation to grant it. Scope columns match ScopedPermission.
+type Delegation struct {
+	ID               uint       `gorm:"primaryKey"`
+	DelegatorID      uint       `gorm:"not null;index"`
+	DelegateID       uint       `gorm:"not null;index"`
+	Permissions      StringList `gorm:"type:text;not null"` // Delegated permission names
+	DepartmentID     *uint      `gorm:"index"`
+	ExactDepartment  bool       `gorm:"default:false"`
+	TargetEmployeeID *uint      `gorm:"column:employee_id;index"`
+	ResourceType     string     `gorm:"index:idx_delegations_resource"`
+	ResourceID       string     `gorm:"index:idx_delegations_resource"`
+	StartsAt         time.Time  `gorm:"not null"`
+	EndsAt           time.Time  `gorm:"not null;index"`
+	RevokedAt        *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
+}
+
+// ImpersonationSession lets an actor check permissions as another employee.
+type ImpersonationSession struct {
+	ID                  uint       `gorm:"primaryKey"`
+	ActorID             uint       `gorm:"not null;index"` // Real employee doing the impersonating
+	SubjectID           uint       `gorm:"not null;index"` // Employee being impersonated
+	Reason              string     `gorm:"not null"`
+	ExcludedPermissions StringList `gorm:"type:text"` // Denied during the session in addition to sensitive permissions
+	ExpiresAt           time.Time  `gorm:"not null"`
+	EndedAt             *time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           gorm.DeletedAt `gorm:"index"`
+}
+
+// BreakGlassGrant records an emergency elevation to the break-glass role.
+type BreakGlassGrant struct {
+	ID            uint       `gorm:"primaryKey"`
+	EmployeeID    uint       `gorm:"not null;index"`
+	RoleID        uint       `gorm:"not null"`
+	Justification string     `gorm:"not null"`
+	ExpiresAt     time.Time  `gorm:"not null;index"`
+	EndedAt       *time.Time // Set when revoked early or swept after expiry
+
+	// PreviousExpiresAt is the expiry of a shorter assignment of the role that
+	// the grant extended, restored if the grant is revoked. It is nil when the
+	// grant created the assignment or found a longer one.
+	PreviousExpiresAt *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	DeletedAt         gorm.DeletedAt `gorm:"index"`
+}
+
+// AccessRequest is an employee's request for time-bound access to a role,
+// or to a permission within a department, pending approval.
+type AccessRequest struct {
+	ID            uint                `gorm:"primaryKey"`
+	RequesterID   uint                `gorm:"not null;index"`
+	RoleID        *uint               `gorm:"index"` // Requested role
+	PermissionID  *uint               `gorm:"index"` // Requested permission, when no role is requested
+	DepartmentID  *uint               `gorm:"index"` // Department the permission is requested in
+	Reason        string              `gorm:"not null"`
+	Duration      time.Duration       `gorm:"not null"` // How long access lasts once approved
+	Status        AccessRequestStatus `gorm:"not null;default:pending;index"`
+	DecidedBy     *uint
+	DecidedAt     *time.Time
+	DecisionNote  string
+	GrantedRoleID *uint      // Role assigned on approval
+	ExpiresAt     *time.Time // When the granted access lapses
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	DeletedAt     gorm.DeletedAt `gorm:"index"`
+}
+
+// CertificationCampaign is a review in which reviewers confirm or revoke
+// each role assignment in scope, as snapshotted when the campaign started.
+type CertificationCampaign struct {
+	ID           uint           `gorm:"primaryKey"`
+	Name         string         `gorm:"not null"`
+	DepartmentID *uint          `gorm:"index"`     // Scope: roles in this department or beneath; nil for all
+	RoleIDs      IDList         `gorm:"type:text"` // Scope: only these roles; empty for all
+	Reviewers    ReviewerSource `gorm:"not null"`
+	Status       CampaignStatus `gorm:"not null;default:open;index"`
+	CreatedBy    uint           `gorm:"not null"`
+	DueAt        *time.Time
+	ClosedBy     *uint
+	ClosedAt     *time.Time
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
 }
 
+// CertificationItem is one role assignment under review in a campaign.
+type CertificationItem struct {
+	ID          uint                  `gorm:"primaryKey"`
+	CampaignID  uint                  `gorm:"not null;index"`
+	EmployeeID  uint                  `gorm:"not null;index"`
+	RoleID      uint                  `gorm:"not null"`
+	RoleName    string                `gorm:"not null"`
+	Grants      StringList            `gorm:"type:text"` // The role's scoped permissions when the campaign started
+	ReviewerID  *uint                 `gorm:"index"`     // Nil when no reviewer could be found
+	Decision    CertificationDecision `gorm:"not null;default:pending;index"`
+	Note        string
+	DecidedAt   *time.Time
+	RevokedAt   *time.Time // Set when the campaign closed and the assignment was removed
+	RevokeError string     // Why removing the assignment failed
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+// SoDConstraint is a separation-of-duties rule: no employee may hold more
+// than one of its roles, or more than one of its permissions, whether
+// directly, through role inheritance or through groups.
+type SoDConstraint struct {
+	ID          uint       `gorm:"primaryKey"`
+	Name        string     `gorm:"not null;uniqueIndex:idx_sod_constraints_name,where:deleted_at IS NULL"` // Unique among live rows
+	RoleIDs     IDList     `gorm:"type:text"`                                                              // Mutually exclusive roles
+	Permissions StringList `gorm:"type:text"`                                                              // Permissions that cannot be combined
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+}
+
+// TableName keeps GORM from naming the table "so_d_constraints".
+func (SoDConstraint) TableName() string {
+	return "sod_constraints"
+}
+
+// Snapshot is a named point-in-time copy of departments, permissions, roles,
+// grants and direct role assignments, stored as an Export archive.
+type Snapshot struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"not null;uniqueIndex:idx_snapshots_name,where:deleted_at IS NULL"` // Unique among live rows
+	Archive   string `gorm:"type:text;not null"`                                               // JSON Archive
+	CreatedBy uint   `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// EmployeeRoleHistory records a period during which a direct role
+// assignment was in place. History rows are written by the library on every
+// change and are never updated except to close them.
+type EmployeeRoleHistory struct {
+	ID         uint       `gorm:"primaryKey"`
+	EmployeeID uint       `gorm:"not null;index:idx_employee_role_histories_key;index:idx_employee_role_histories_open,where:valid_to IS NULL"`
+	RoleID     uint       `gorm:"not null;index:idx_employee_role_histories_key;index:idx_employee_role_histories_open"`
+	ExpiresAt  *time.Time // The assignment's expiry during the period
+	ValidFrom  time.Time  `gorm:"not null;index"`
+	ValidTo    *time.Time `gorm:"index"` // Nil while the period is open
+}
+
+// ScopedPermissionHistory records a period during which a grant was in place.
+type ScopedPermissionHistory struct {
+	ID              uint `gorm:"primaryKey"`
+	GrantID         uint `gorm:"not null;index;index:idx_scoped_permission_histories_open,where:valid_to IS NULL"`
+	RoleID          uint `gorm:"not null;index"`
+	PermissionID    uint `gorm:"not null;index"`
+	DepartmentID    *uint
+	EmployeeID      *uint
+	ExactDepartment bool
+	ScopeKind       ScopeKind
+	ResourceType    string
+	ResourceID      string
+	ValidFrom       time.Time  `gorm:"not null;index"`
+	ValidTo         *time.Time `gorm:"index"`
+}
+
+// RoleHistory records a period during which a role existed with a given
+// parent, department and global flag.
+type RoleHistory struct {
+	ID           uint `gorm:"primaryKey"`
+	RoleID       uint `gorm:"not null;index;index:idx_role_histories_open,where:valid_to IS NULL"`
+	ParentRoleID *uint
+	DepartmentID uint `gorm:"not null"`
+	IsGlobal     bool
+	ValidFrom    time.Time  `gorm:"not null;index"`
+	ValidTo      *time.Time `gorm:"index"`
+}
+
+// GroupMemberHistory records a period during which an employee or nested
+// group belonged to a group.
+type GroupMemberHistory struct {
+	ID            uint       `gorm:"primaryKey"`
+	GroupID       uint       `gorm:"not null;index;index:idx_group_member_histories_open,where:valid_to IS NULL"`
+	EmployeeID    *uint      `gorm:"index"`
+	MemberGroupID *uint      `gorm:"index"`
+	ValidFrom     time.Time  `gorm:"not null;index"`
+	ValidTo       *time.Time `gorm:"index"`
+}
+
+// GroupRoleHistory records a period during which a group held a role.
+type GroupRoleHistory struct {
+	ID        uint       `gorm:"primaryKey"`
+	GroupID   uint       `gorm:"not null;index;index:idx_group_role_histories_open,where:valid_to IS NULL"`
+	RoleID    uint       `gorm:"not null;index;index:idx_group_role_histories_open"`
+	ValidFrom time.Time  `gorm:"not null;index"`
+	ValidTo   *time.Time `gorm:"index"`
+}
+
+// ScopedPermission grants a permission to a role with optional scoping.
+type ScopedPermission struct {
+	ID              uint      `gorm:"primaryKey"`
+	RoleID          uint      `gorm:"index;not null"`
+	PermissionID    uint      `gorm:"index;not null"`
+	DepartmentID    *uint     `gorm:"index"`                                 // Optional department scope
+	EmployeeID      *uint     `gorm:"index"`                                 // Optional employee scope
+	ExactDepartment bool      `gorm:"default:false"`                         // Department scope excludes descendant departments
+	ScopeKind       ScopeKind `gorm:"index"`                                 // Optional scope relative to the caller
+	ResourceType    string    `gorm:"index:idx_scoped_permissions_resource"` // Optional resource scope type
+	ResourceID      string    `gorm:"index:idx_scoped_permissions_resource"` // Optional resource scope ID; empty means any
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
+}
+
+// RelationTuple records that a subject has a relation to an object, such as
+// "employee:7 is viewer of document:42" or "team:3#member is viewer of folder:7".
+type RelationTuple struct {
+	ID               uint   `gorm:"primaryKey"`
+	Namespace        string `gorm:"not null;index:idx_relation_tuples_object"`
+	ObjectID         string `gorm:"not null;index:idx_relation_tuples_object"`
+	Relation         string `gorm:"not null;index:idx_relation_tuples_object"`
+	SubjectNamespace string `gorm:"not null;index:idx_relation_tuples_subject"`
+	SubjectID        string `gorm:"not null;index:idx_relation_tuples_subject"`
+	SubjectRelation  string // Set when the subject is a userset, e.g. team:3#member
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	DeletedAt        gorm.DeletedAt `gorm:"index"`
+}
+
 // AuditLog tracks permission/role-related events.
 type AuditLog struct {
 	ID         uint   `gorm:"primaryKey"`
@@ -65,6 +358,8 @@ type AuditLog struct {
 	Action     string `gorm:"not null"`
 	TargetType string `gorm:"not null"`
 	TargetID   uint   `gorm:"index;not null"`
+	SubjectID  *uint  `gorm:"index"`                       // Impersonated employee, when the actor was acting as someone else
+	Severity   string `gorm:"not null;default:info;index"` // SeverityInfo or SeverityHigh
 	Details    string
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
@@ -1,6 +1,13 @@
 package rbac
 
-// CreatePermission creates a new permission.
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// CreatePermission creates a new permission. A global permission is held
+// implicitly by every employee and ignores department and employee scope.
 func (r *RBAC) CreatePermission(name string, isGlobal bool) (*Permission, error) {
 	if name == "" {
 		return nil, ErrInvalidInput
@@ -37,6 +44,26 @@ func (r *RBAC) UpdatePermission(id uint, name string, isGlobal bool) (*Permissio
 	return &perm, nil
 }
 
+// SetPermissionSensitive marks a permission as sensitive. Sensitive
+// permissions are denied to anyone impersonating another employee.
+func (r *RBAC) SetPermissionSensitive(id uint, sensitive bool) error {
+	if id == 0 {
+		return ErrInvalidInput
+	}
+
+	var perm Permission
+	if err := r.db.First(&perm, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if err := r.db.Model(&perm).Update("sensitive", sensitive).Error; err != nil {
+		return err
+	}
+
+	r.logAudit(0, "set_permission_sensitive", "permission", id, fmt.Sprintf("Set permission %s sensitive: %t", perm.Name, sensitive))
+	return nil
+}
+
 // GetPermission retrieves a permission by ID.
 func (r *RBAC) GetPermission(id uint) (*Permission, error) {
 	if id == 0 {
@@ -79,3 +106,42 @@ func (r *RBAC) ListPermissions() ([]Permission, error) {
 	}
 	return perms, nil
 }
+
+// RestorePermission undeletes a soft-deleted permission. It fails with
+// ErrInvalidInput if its name has been taken by another permission since.
+func (r *RBAC) RestorePermission(id uint) (*Permission, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var perm Permission
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").First(&perm, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	var taken int64
+	if err := r.db.Model(&Permission{}).Where("name = ?", perm.Name).Count(&taken).Error; err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, fmt.Errorf("%w: permission name %q is in use", ErrInvalidInput, perm.Name)
+	}
+
+	if err := r.db.Unscoped().Model(&perm).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	perm.DeletedAt = gorm.DeletedAt{}
+
+	r.invalidateCache(0) // Grants of the permission apply again
+	r.logAudit(0, "restore_permission", "permission", id, "Restored permission: "+perm.Name)
+	return &perm, nil
+}
+
+// ListDeletedPermissions retrieves soft-deleted permissions, most recently
+// deleted first.
+func (r *RBAC) ListDeletedPermissions() ([]Permission, error) {
+	var perms []Permission
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&perms).Error; err != nil {
+		return nil, err
+	}
+	return perms, nil
+}
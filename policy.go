@@ -0,0 +1,1251 @@
+package rbac
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// PolicyVersion is the policy document format understood by ParsePolicy.
+const PolicyVersion = 1
+
+// Policy declares departments, permissions, the role tree and each role's
+// scoped grants. A policy is self-contained: every department, role and
+// permission it refers to must be declared in it. Objects are matched to the
+// database by name; roles by department and name.
+type Policy struct {
+	Version     int                `json:"version" yaml:"version"`
+	Departments []PolicyDepartment `json:"departments,omitempty" yaml:"departments,omitempty"`
+	Permissions []PolicyPermission `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	Roles       []PolicyRole       `json:"roles,omitempty" yaml:"roles,omitempty"`
+}
+
+// PolicyDepartment declares a department.
+type PolicyDepartment struct {
+	Name   string `json:"name" yaml:"name"`
+	Parent string `json:"parent,omitempty" yaml:"parent,omitempty"` // Parent department's name
+}
+
+// PolicyPermission declares a permission.
+type PolicyPermission struct {
+	Name      string `json:"name" yaml:"name"`
+	Global    bool   `json:"global,omitempty" yaml:"global,omitempty"`
+	Sensitive bool   `json:"sensitive,omitempty" yaml:"sensitive,omitempty"`
+}
+
+// PolicyRole declares a role and its grants.
+type PolicyRole struct {
+	Name             string          `json:"name" yaml:"name"`
+	Department       string          `json:"department" yaml:"department"`
+	Parent           string          `json:"parent,omitempty" yaml:"parent,omitempty"`                       // Parent role's name
+	ParentDepartment string          `json:"parent_department,omitempty" yaml:"parent_department,omitempty"` // Parent role's department; defaults to Department
+	Global           bool            `json:"global,omitempty" yaml:"global,omitempty"`
+	Owner            *uint           `json:"owner,omitempty" yaml:"owner,omitempty"`                 // Employee who approves access requests for the role
+	Prerequisites    []PolicyRoleRef `json:"prerequisites,omitempty" yaml:"prerequisites,omitempty"` // Roles an employee must hold before being assigned this one
+	MaxMembers       int             `json:"max_members,omitempty" yaml:"max_members,omitempty"`     // Cap on direct assignments; zero means unlimited
+	Grants           []PolicyGrant   `json:"grants,omitempty" yaml:"grants,omitempty"`
+}
+
+// PolicyRoleRef refers to a declared role.
+type PolicyRoleRef struct {
+	Name       string `json:"name" yaml:"name"`
+	Department string `json:"department,omitempty" yaml:"department,omitempty"` // Defaults to the referring role's department
+}
+
+// PolicyGrant declares a scoped permission held by a role. Unset scope
+// fields leave the grant unscoped in that respect, as with ScopedPermission.
+type PolicyGrant struct {
+	Permission      string    `json:"permission" yaml:"permission"`
+	Department      string    `json:"department,omitempty" yaml:"department,omitempty"`
+	ExactDepartment bool      `json:"exact_department,omitempty" yaml:"exact_department,omitempty"`
+	Employee        *uint     `json:"employee,omitempty" yaml:"employee,omitempty"`
+	Relative        ScopeKind `json:"relative,omitempty" yaml:"relative,omitempty"`
+	ResourceType    string    `json:"resource_type,omitempty" yaml:"resource_type,omitempty"`
+	ResourceID      string    `json:"resource_id,omitempty" yaml:"resource_id,omitempty"`
+}
+
+// PlanAction is what a plan does to an object.
+type PlanAction string
+
+// Plan actions.
+const (
+	PlanCreate PlanAction = "create"
+	PlanUpdate PlanAction = "update"
+	PlanDelete PlanAction = "delete"
+)
+
+// PlanOptions controls how Plan compares a policy with the database.
+type PlanOptions struct {
+	// Prune deletes departments, permissions, roles and grants that the
+	// policy does not declare. A pruned role is also taken from the employees
+	// and groups holding it, and from other roles' prerequisites and from
+	// separation-of-duties constraints. Without it, unmanaged objects are
+	// left alone.
+	Prune bool
+}
+
+// PlanChange is one change a plan would make.
+type PlanChange struct {
+	Action  PlanAction `json:"action"`
+	Kind    string     `json:"kind"`         // "department", "permission", "role" or "grant"
+	Name    string     `json:"name"`         // Natural key, e.g. "Finance/Approver" for a role
+	ID      uint       `json:"id,omitempty"` // Existing object, for updates and deletes
+	Details string     `json:"details,omitempty"`
+}
+
+// String formats the change as a line of a plan, e.g. "+ role Finance/Approver".
+func (c PlanChange) String() string {
+	sign := map[PlanAction]string{PlanCreate: "+", PlanUpdate: "~", PlanDelete: "-"}[c.Action]
+	line := fmt.Sprintf("%s %s %s", sign, c.Kind, c.Name)
+	if c.Details != "" {
+		line += " (" + c.Details + ")"
+	}
+	return line
+}
+
+// Plan is the difference between a policy and the database at the time it
+// was computed.
+type Plan struct {
+	Policy  *Policy
+	Options PlanOptions
+	Changes []PlanChange
+}
+
+// Empty reports whether the database already matches the policy.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// String formats the plan one change per line.
+func (p *Plan) String() string {
+	lines := make([]string, len(p.Changes))
+	for i, c := range p.Changes {
+		lines[i] = c.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParsePolicy decodes a policy document in JSON or YAML and validates it.
+// Unknown fields are rejected so that typos do not go unnoticed.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&policy); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(trimmed))
+		dec.KnownFields(true)
+		if err := dec.Decode(&policy); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidInput, err)
+		}
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Validate checks that the policy is self-contained, has no duplicates and
+// that its department and role trees have no cycles.
+func (p *Policy) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: policy: %s", ErrInvalidInput, fmt.Sprintf(format, args...))
+	}
+	if p.Version != PolicyVersion {
+		return invalid("unsupported version %d", p.Version)
+	}
+
+	deptParents := make(map[string]string, len(p.Departments))
+	for _, d := range p.Departments {
+		if d.Name == "" {
+			return invalid("department without a name")
+		}
+		if _, ok := deptParents[d.Name]; ok {
+			return invalid("department %q declared twice", d.Name)
+		}
+		deptParents[d.Name] = d.Parent
+	}
+	for _, d := range p.Departments {
+		if d.Parent != "" {
+			if _, ok := deptParents[d.Parent]; !ok {
+				return invalid("department %q has undeclared parent %q", d.Name, d.Parent)
+			}
+		}
+		for seen, at := 0, d.Parent; at != ""; at = deptParents[at] {
+			if at == d.Name || seen > len(deptParents) {
+				return invalid("department %q is its own ancestor", d.Name)
+			}
+			seen++
+		}
+	}
+
+	perms := make(map[string]bool, len(p.Permissions))
+	for _, perm := range p.Permissions {
+		if perm.Name == "" {
+			return invalid("permission without a name")
+		}
+		if perms[perm.Name] {
+			return invalid("permission %q declared twice", perm.Name)
+		}
+		perms[perm.Name] = true
+	}
+
+	roleParents := make(map[roleKey]roleKey, len(p.Roles))
+	for _, role := range p.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		if role.Name == "" {
+			return invalid("role without a name")
+		}
+		if _, ok := deptParents[role.Department]; !ok {
+			return invalid("role %s has undeclared department %q", key, role.Department)
+		}
+		if _, ok := roleParents[key]; ok {
+			return invalid("role %s declared twice", key)
+		}
+		roleParents[key] = role.parentKey()
+	}
+	for _, role := range p.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		if parent := role.parentKey(); parent.Name != "" {
+			if _, ok := roleParents[parent]; !ok {
+				return invalid("role %s has undeclared parent %s", key, parent)
+			}
+		}
+		for seen, at := 0, role.parentKey(); at.Name != ""; at = roleParents[at] {
+			if at == key || seen > len(roleParents) {
+				return invalid("role %s is its own ancestor", key)
+			}
+			seen++
+		}
+
+		if role.Owner != nil && *role.Owner == 0 {
+			return invalid("role %s has an invalid owner", key)
+		}
+		if role.MaxMembers < 0 {
+			return invalid("role %s has a negative member limit", key)
+		}
+		required := make(map[roleKey]bool, len(role.Prerequisites))
+		for _, prereq := range role.prerequisiteKeys() {
+			if _, ok := roleParents[prereq]; !ok {
+				return invalid("role %s requires undeclared role %s", key, prereq)
+			}
+			if required[prereq] {
+				return invalid("role %s requires %s twice", key, prereq)
+			}
+			required[prereq] = true
+		}
+		if p.requiresItself(key) {
+			return invalid("role %s is its own prerequisite", key)
+		}
+
+		grants := make(map[grantKey]bool, len(role.Grants))
+		for _, g := range role.Grants {
+			if !perms[g.Permission] {
+				return invalid("role %s grants undeclared permission %q", key, g.Permission)
+			}
+			if g.Department != "" {
+				if _, ok := deptParents[g.Department]; !ok {
+					return invalid("role %s grants %s in undeclared department %q", key, g.Permission, g.Department)
+				}
+			} else if g.ExactDepartment {
+				return invalid("role %s grants %s exactly without a department", key, g.Permission)
+			}
+			if g.Relative != "" && !g.Relative.valid() {
+				return invalid("role %s grants %s with unknown relative scope %q", key, g.Permission, g.Relative)
+			}
+			if g.ResourceType == "" && g.ResourceID != "" || strings.ContainsAny(g.ResourceType, "/:*") {
+				return invalid("role %s grants %s on an invalid resource", key, g.Permission)
+			}
+			gk := policyGrantKey(key, g)
+			if grants[gk] {
+				return invalid("role %s grants %s twice", key, gk.scopeString())
+			}
+			grants[gk] = true
+		}
+	}
+	return nil
+}
+
+// requiresItself reports whether a role is, transitively, a prerequisite of
+// itself, so that no one could ever be assigned it.
+func (p *Policy) requiresItself(key roleKey) bool {
+	requires := make(map[roleKey][]roleKey, len(p.Roles))
+	for _, role := range p.Roles {
+		requires[roleKey{Department: role.Department, Name: role.Name}] = role.prerequisiteKeys()
+	}
+	visited := make(map[roleKey]bool)
+	stack := append([]roleKey(nil), requires[key]...)
+	for len(stack) > 0 {
+		at := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if at == key {
+			return true
+		}
+		if visited[at] {
+			continue
+		}
+		visited[at] = true
+		stack = append(stack, requires[at]...)
+	}
+	return false
+}
+
+// prerequisiteKeys identifies the role's prerequisites.
+func (role PolicyRole) prerequisiteKeys() []roleKey {
+	keys := make([]roleKey, 0, len(role.Prerequisites))
+	for _, ref := range role.Prerequisites {
+		dept := ref.Department
+		if dept == "" {
+			dept = role.Department
+		}
+		keys = append(keys, roleKey{Department: dept, Name: ref.Name})
+	}
+	return keys
+}
+
+// parentKey identifies the role's parent, if it has one.
+func (role PolicyRole) parentKey() roleKey {
+	if role.Parent == "" {
+		return roleKey{}
+	}
+	dept := role.ParentDepartment
+	if dept == "" {
+		dept = role.Department
+	}
+	return roleKey{Department: dept, Name: role.Parent}
+}
+
+// roleKey identifies a role by natural key.
+type roleKey struct {
+	Department string
+	Name       string
+}
+
+func (k roleKey) String() string {
+	return k.Department + "/" + k.Name
+}
+
+// grantKey identifies a scoped grant by natural key.
+type grantKey struct {
+	Role         roleKey
+	Permission   string
+	Department   string
+	Exact        bool
+	Employee     uint
+	Relative     ScopeKind
+	ResourceType string
+	ResourceID   string
+}
+
+// scopeString describes the grant without its role, e.g.
+// "invoice.pay in Finance only".
+func (k grantKey) scopeString() string {
+	desc := k.Permission
+	if k.Department != "" {
+		desc += " in " + k.Department
+		if k.Exact {
+			desc += " only"
+		}
+	}
+	if k.Employee != 0 {
+		desc += fmt.Sprintf(" for employee %d", k.Employee)
+	}
+	if k.Relative != "" {
+		desc += " for " + string(k.Relative)
+	}
+	if k.ResourceType != "" {
+		desc += " on " + ResourceRef{Type: k.ResourceType, ID: k.ResourceID}.String()
+	}
+	return desc
+}
+
+func (k grantKey) String() string {
+	return k.Role.String() + ": " + k.scopeString()
+}
+
+// policyGrantKey is the key of a grant declared on a role.
+func policyGrantKey(role roleKey, g PolicyGrant) grantKey {
+	key := grantKey{
+		Role:         role,
+		Permission:   g.Permission,
+		Department:   g.Department,
+		Exact:        g.ExactDepartment,
+		Relative:     g.Relative,
+		ResourceType: g.ResourceType,
+		ResourceID:   g.ResourceID,
+	}
+	if g.Employee != nil {
+		key.Employee = *g.Employee
+	}
+	return key
+}
+
+// policyState is the database as a policy sees it, indexed by natural key.
+type policyState struct {
+	departments map[string]Department
+	deptNames   map[uint]string
+	permissions map[string]Permission
+	permNames   map[uint]string
+	roles       map[roleKey]Role
+	roleKeys    map[uint]roleKey
+	duplicates  []Role // Roles sharing another role's key; never managed
+	grants      map[grantKey]ScopedPermission
+	grantOrder  []grantKey
+}
+
+// newPolicyState returns an empty state.
+func newPolicyState() *policyState {
+	return &policyState{
+		departments: make(map[string]Department),
+		deptNames:   make(map[uint]string),
+		permissions: make(map[string]Permission),
+		permNames:   make(map[uint]string),
+		roles:       make(map[roleKey]Role),
+		roleKeys:    make(map[uint]roleKey),
+		grants:      make(map[grantKey]ScopedPermission),
+	}
+}
+
+// policyStateOf is the state an empty database would be in once the policy
+// was applied, with IDs assigned in declaration order.
+func policyStateOf(policy *Policy) *policyState {
+	s := newPolicyState()
+	for i, d := range policy.Departments {
+		id := uint(i + 1)
+		s.departments[d.Name] = Department{ID: id, Name: d.Name}
+		s.deptNames[id] = d.Name
+	}
+	for _, d := range policy.Departments {
+		if d.Parent != "" {
+			dept := s.departments[d.Name]
+			parentID := s.departments[d.Parent].ID
+			dept.ParentDepartmentID = &parentID
+			s.departments[d.Name] = dept
+		}
+	}
+	for i, p := range policy.Permissions {
+		id := uint(i + 1)
+		s.permissions[p.Name] = Permission{ID: id, Name: p.Name, IsGlobal: p.Global, Sensitive: p.Sensitive}
+		s.permNames[id] = p.Name
+	}
+	for i, role := range policy.Roles {
+		id := uint(i + 1)
+		key := roleKey{Department: role.Department, Name: role.Name}
+		s.roles[key] = Role{ID: id, Name: role.Name, DepartmentID: s.departments[role.Department].ID, IsGlobal: role.Global,
+			OwnerID: role.Owner, MaxMembers: role.MaxMembers}
+		s.roleKeys[id] = key
+	}
+	var grantID uint
+	for _, role := range policy.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		r := s.roles[key]
+		if parent := role.parentKey(); parent.Name != "" {
+			parentID := s.roles[parent].ID
+			r.ParentRoleID = &parentID
+		}
+		r.Prerequisites = s.roleIDs(role.prerequisiteKeys())
+		s.roles[key] = r
+		for _, g := range role.Grants {
+			grantID++
+			gk := policyGrantKey(key, g)
+			s.grants[gk] = ScopedPermission{ID: grantID, RoleID: s.roles[key].ID}
+			s.grantOrder = append(s.grantOrder, gk)
+		}
+	}
+	return s
+}
+
+// loadPolicyState reads every live department, permission, role and grant.
+func loadPolicyState(db *gorm.DB) (*policyState, error) {
+	s := newPolicyState()
+
+	var depts []Department
+	if err := db.Order("id").Find(&depts).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range depts {
+		s.departments[d.Name] = d
+		s.deptNames[d.ID] = d.Name
+	}
+
+	var perms []Permission
+	if err := db.Order("id").Find(&perms).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range perms {
+		s.permissions[p.Name] = p
+		s.permNames[p.ID] = p.Name
+	}
+
+	var roles []Role
+	if err := db.Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		key := roleKey{Department: s.deptName(role.DepartmentID), Name: role.Name}
+		if _, ok := s.roles[key]; ok {
+			s.duplicates = append(s.duplicates, role)
+			continue
+		}
+		s.roles[key] = role
+		s.roleKeys[role.ID] = key
+	}
+
+	var grants []ScopedPermission
+	if err := db.Order("id").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		rk, ok := s.roleKeys[g.RoleID]
+		if !ok {
+			rk = roleKey{Name: fmt.Sprintf("#%d", g.RoleID)} // Duplicate or deleted role
+		}
+		key := grantKey{
+			Role:         rk,
+			Permission:   s.permNames[g.PermissionID],
+			Exact:        g.ExactDepartment,
+			Relative:     g.ScopeKind,
+			ResourceType: g.ResourceType,
+			ResourceID:   g.ResourceID,
+		}
+		if key.Permission == "" {
+			key.Permission = fmt.Sprintf("#%d", g.PermissionID)
+		}
+		if g.DepartmentID != nil {
+			key.Department = s.deptName(*g.DepartmentID)
+		}
+		if g.EmployeeID != nil {
+			key.Employee = *g.EmployeeID
+		}
+		if _, ok := s.grants[key]; ok {
+			key.ResourceID += fmt.Sprintf("#%d", g.ID) // Keep exact duplicates apart so they can be pruned
+		}
+		s.grants[key] = g
+		s.grantOrder = append(s.grantOrder, key)
+	}
+	return s, nil
+}
+
+// roleIDs resolves declared role keys to IDs, or nil if there are none.
+func (s *policyState) roleIDs(keys []roleKey) IDList {
+	if len(keys) == 0 {
+		return nil
+	}
+	ids := make(IDList, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, s.roles[key].ID)
+	}
+	return ids
+}
+
+// prerequisiteNames describes a role's prerequisites in order, with "#id"
+// for roles that no longer exist.
+func (s *policyState) prerequisiteNames(ids IDList) string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		key, ok := s.roleKeys[id]
+		if !ok {
+			key = roleKey{Name: fmt.Sprintf("#%d", id)}
+		}
+		names = append(names, roleKeyString(key))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// deptName returns a department's name, or "#id" if it no longer exists.
+func (s *policyState) deptName(id uint) string {
+	if name, ok := s.deptNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("#%d", id)
+}
+
+// Plan computes the changes needed to make the database match the policy.
+// The plan is only a preview; nothing is changed until it is applied.
+func (r *RBAC) Plan(policy *Policy, opts PlanOptions) (*Plan, error) {
+	if policy == nil {
+		return nil, ErrInvalidInput
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	changes, err := planChanges(r.db, policy, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{Policy: policy, Options: opts, Changes: changes}, nil
+}
+
+// planChanges diffs the policy against the database.
+func planChanges(db *gorm.DB, policy *Policy, opts PlanOptions) ([]PlanChange, error) {
+	s, err := loadPolicyState(db)
+	if err != nil {
+		return nil, err
+	}
+	return diffPolicy(s, policy, opts), nil
+}
+
+// diffPolicy lists the changes that turn the state into the policy.
+func diffPolicy(s *policyState, policy *Policy, opts PlanOptions) []PlanChange {
+	var changes []PlanChange
+	for _, d := range policy.Departments {
+		existing, ok := s.departments[d.Name]
+		if !ok {
+			changes = append(changes, PlanChange{Action: PlanCreate, Kind: "department", Name: d.Name, Details: parentDetails(d.Parent)})
+			continue
+		}
+		current := ""
+		if existing.ParentDepartmentID != nil {
+			current = s.deptName(*existing.ParentDepartmentID)
+		}
+		if current != d.Parent {
+			changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "department", Name: d.Name, ID: existing.ID,
+				Details: fmt.Sprintf("parent %s -> %s", orNone(current), orNone(d.Parent))})
+		}
+	}
+
+	for _, p := range policy.Permissions {
+		existing, ok := s.permissions[p.Name]
+		if !ok {
+			changes = append(changes, PlanChange{Action: PlanCreate, Kind: "permission", Name: p.Name, Details: permissionFlags(p.Global, p.Sensitive)})
+			continue
+		}
+		if existing.IsGlobal != p.Global || existing.Sensitive != p.Sensitive {
+			changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "permission", Name: p.Name, ID: existing.ID,
+				Details: fmt.Sprintf("%s -> %s", orNone(permissionFlags(existing.IsGlobal, existing.Sensitive)), orNone(permissionFlags(p.Global, p.Sensitive)))})
+		}
+	}
+
+	declaredGrants := make(map[grantKey]bool)
+	for _, role := range policy.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		parent := role.parentKey()
+		existing, ok := s.roles[key]
+		if !ok {
+			var details []string
+			if parent.Name != "" {
+				details = append(details, "parent "+parent.String())
+			}
+			if role.Global {
+				details = append(details, "global")
+			}
+			if role.Owner != nil {
+				details = append(details, fmt.Sprintf("owner %d", *role.Owner))
+			}
+			if len(role.Prerequisites) > 0 {
+				details = append(details, "requires "+policyPrerequisiteNames(role))
+			}
+			if role.MaxMembers > 0 {
+				details = append(details, fmt.Sprintf("max members %d", role.MaxMembers))
+			}
+			changes = append(changes, PlanChange{Action: PlanCreate, Kind: "role", Name: key.String(), Details: strings.Join(details, ", ")})
+		} else {
+			var diffs []string
+			current := roleKey{}
+			if existing.ParentRoleID != nil {
+				current = s.roleKeys[*existing.ParentRoleID]
+				if current.Name == "" {
+					current = roleKey{Name: fmt.Sprintf("#%d", *existing.ParentRoleID)}
+				}
+			}
+			if current != parent {
+				diffs = append(diffs, fmt.Sprintf("parent %s -> %s", orNone(roleKeyString(current)), orNone(roleKeyString(parent))))
+			}
+			if existing.IsGlobal != role.Global {
+				diffs = append(diffs, fmt.Sprintf("global %t -> %t", existing.IsGlobal, role.Global))
+			}
+			if !equalIDPtr(existing.OwnerID, role.Owner) {
+				diffs = append(diffs, fmt.Sprintf("owner %s -> %s", orNone(idString(existing.OwnerID)), orNone(idString(role.Owner))))
+			}
+			if current, declared := s.prerequisiteNames(existing.Prerequisites), policyPrerequisiteNames(role); current != declared {
+				diffs = append(diffs, fmt.Sprintf("requires %s -> %s", orNone(current), orNone(declared)))
+			}
+			if existing.MaxMembers != role.MaxMembers {
+				diffs = append(diffs, fmt.Sprintf("max members %d -> %d", existing.MaxMembers, role.MaxMembers))
+			}
+			if len(diffs) > 0 {
+				changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "role", Name: key.String(), ID: existing.ID, Details: strings.Join(diffs, ", ")})
+			}
+		}
+
+		for _, g := range role.Grants {
+			gk := policyGrantKey(key, g)
+			declaredGrants[gk] = true
+			if _, ok := s.grants[gk]; !ok {
+				changes = append(changes, PlanChange{Action: PlanCreate, Kind: "grant", Name: gk.String()})
+			}
+		}
+	}
+
+	if !opts.Prune {
+		return changes
+	}
+
+	for _, gk := range s.grantOrder {
+		if !declaredGrants[gk] {
+			changes = append(changes, PlanChange{Action: PlanDelete, Kind: "grant", Name: gk.String(), ID: s.grants[gk].ID})
+		}
+	}
+	declaredRoles := make(map[roleKey]bool, len(policy.Roles))
+	for _, role := range policy.Roles {
+		declaredRoles[roleKey{Department: role.Department, Name: role.Name}] = true
+	}
+	var pruneRoles []Role
+	for key, role := range s.roles {
+		if !declaredRoles[key] {
+			pruneRoles = append(pruneRoles, role)
+		}
+	}
+	pruneRoles = append(pruneRoles, s.duplicates...)
+	sort.Slice(pruneRoles, func(i, j int) bool { return pruneRoles[i].ID < pruneRoles[j].ID })
+	for _, role := range pruneRoles {
+		name := roleKey{Department: s.deptName(role.DepartmentID), Name: role.Name}.String()
+		changes = append(changes, PlanChange{Action: PlanDelete, Kind: "role", Name: name, ID: role.ID})
+	}
+	declaredPerms := make(map[string]bool, len(policy.Permissions))
+	for _, p := range policy.Permissions {
+		declaredPerms[p.Name] = true
+	}
+	for _, name := range sortedKeys(s.permissions) {
+		if !declaredPerms[name] {
+			changes = append(changes, PlanChange{Action: PlanDelete, Kind: "permission", Name: name, ID: s.permissions[name].ID})
+		}
+	}
+	declaredDepts := make(map[string]bool, len(policy.Departments))
+	for _, d := range policy.Departments {
+		declaredDepts[d.Name] = true
+	}
+	for _, name := range sortedKeys(s.departments) {
+		if !declaredDepts[name] {
+			changes = append(changes, PlanChange{Action: PlanDelete, Kind: "department", Name: name, ID: s.departments[name].ID})
+		}
+	}
+	return changes
+}
+
+// Apply executes a plan in a single transaction, with an audit entry for
+// every change. The plan is recomputed first; if the database has changed
+// since it was made, Apply fails with ErrStalePlan and changes nothing.
+func (r *RBAC) Apply(plan *Plan) error {
+	if plan == nil || plan.Policy == nil {
+		return ErrInvalidInput
+	}
+	if err := plan.Policy.Validate(); err != nil {
+		return err
+	}
+
+	var audits []PlanChange
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		current, err := planChanges(tx, plan.Policy, plan.Options)
+		if err != nil {
+			return err
+		}
+		if !samePlanChanges(current, plan.Changes) {
+			return ErrStalePlan
+		}
+		if len(current) == 0 {
+			return nil
+		}
+		audits, err = applyPolicy(tx, plan.Policy, plan.Options)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(audits) == 0 {
+		return nil
+	}
+
+	r.invalidateCache(0)
+	r.auditPolicyChanges(0, audits)
+	return nil
+}
+
+// auditPolicyChanges records an audit entry for each change made to bring
+// the database in line with a policy, under the actor who made them.
+func (r *RBAC) auditPolicyChanges(actorID uint, changes []PlanChange) {
+	for _, c := range changes {
+		details := c.Name
+		if c.Details != "" {
+			details += " (" + c.Details + ")"
+		}
+		r.logAudit(actorID, "policy_"+string(c.Action), c.Kind, c.ID, details)
+	}
+}
+
+// applyPolicy brings the database in line with the policy, in dependency
+// order, and returns the changes made with the IDs of created objects.
+func applyPolicy(tx *gorm.DB, policy *Policy, opts PlanOptions) ([]PlanChange, error) {
+	var done []PlanChange
+
+	// Departments: create, then link parents once every department exists.
+	// Linking a new object is part of its creation, not a separate change.
+	s, err := loadPolicyState(tx)
+	if err != nil {
+		return nil, err
+	}
+	created := make(map[string]bool)
+	for _, d := range policy.Departments {
+		if _, ok := s.departments[d.Name]; ok {
+			continue
+		}
+		dept := Department{Name: d.Name}
+		if err := createOrRestore(tx, &dept, "name = ?", d.Name); err != nil {
+			return nil, err
+		}
+		s.departments[d.Name] = dept
+		created[d.Name] = true
+		done = append(done, PlanChange{Action: PlanCreate, Kind: "department", Name: d.Name, ID: dept.ID})
+	}
+	for _, d := range policy.Departments {
+		dept := s.departments[d.Name]
+		var parentID *uint
+		if d.Parent != "" {
+			id := s.departments[d.Parent].ID
+			parentID = &id
+		}
+		if equalIDPtr(dept.ParentDepartmentID, parentID) {
+			continue
+		}
+		if err := tx.Model(&Department{}).Where("id = ?", dept.ID).Update("parent_department_id", parentID).Error; err != nil {
+			return nil, err
+		}
+		if created[d.Name] {
+			continue
+		}
+		done = append(done, PlanChange{Action: PlanUpdate, Kind: "department", Name: d.Name, ID: dept.ID, Details: parentDetails(d.Parent)})
+	}
+
+	// Permissions
+	for _, p := range policy.Permissions {
+		existing, ok := s.permissions[p.Name]
+		if !ok {
+			perm := Permission{Name: p.Name, IsGlobal: p.Global, Sensitive: p.Sensitive}
+			if err := createOrRestore(tx, &perm, "name = ?", p.Name); err != nil {
+				return nil, err
+			}
+			// A restored permission keeps its old flags
+			if err := tx.Model(&perm).Updates(map[string]interface{}{"is_global": p.Global, "sensitive": p.Sensitive}).Error; err != nil {
+				return nil, err
+			}
+			s.permissions[p.Name] = perm
+			done = append(done, PlanChange{Action: PlanCreate, Kind: "permission", Name: p.Name, ID: perm.ID})
+			continue
+		}
+		if existing.IsGlobal == p.Global && existing.Sensitive == p.Sensitive {
+			continue
+		}
+		if err := tx.Model(&Permission{}).Where("id = ?", existing.ID).
+			Updates(map[string]interface{}{"is_global": p.Global, "sensitive": p.Sensitive}).Error; err != nil {
+			return nil, err
+		}
+		done = append(done, PlanChange{Action: PlanUpdate, Kind: "permission", Name: p.Name, ID: existing.ID, Details: permissionFlags(p.Global, p.Sensitive)})
+	}
+
+	// Roles: create, then link parents once every role exists. A restored
+	// role keeps its old settings until it is linked.
+	createdRoles := make(map[roleKey]bool)
+	for _, role := range policy.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		if _, ok := s.roles[key]; ok {
+			continue
+		}
+		newRole := Role{Name: role.Name, DepartmentID: s.departments[role.Department].ID, IsGlobal: role.Global,
+			OwnerID: role.Owner, MaxMembers: role.MaxMembers}
+		if err := createOrRestore(tx, &newRole, "department_id = ? AND name = ?", newRole.DepartmentID, role.Name); err != nil {
+			return nil, err
+		}
+		s.roles[key] = newRole
+		s.roleKeys[newRole.ID] = key
+		createdRoles[key] = true
+		done = append(done, PlanChange{Action: PlanCreate, Kind: "role", Name: key.String(), ID: newRole.ID})
+	}
+	for _, role := range policy.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		existing := s.roles[key]
+		var parentID *uint
+		if parent := role.parentKey(); parent.Name != "" {
+			id := s.roles[parent].ID
+			parentID = &id
+		}
+		prereqs := s.roleIDs(role.prerequisiteKeys())
+		if equalIDPtr(existing.ParentRoleID, parentID) && existing.IsGlobal == role.Global && equalIDPtr(existing.OwnerID, role.Owner) &&
+			s.prerequisiteNames(existing.Prerequisites) == s.prerequisiteNames(prereqs) && existing.MaxMembers == role.MaxMembers {
+			continue
+		}
+		if err := tx.Model(&Role{}).Where("id = ?", existing.ID).
+			Updates(map[string]interface{}{
+				"parent_role_id": parentID,
+				"is_global":      role.Global,
+				"owner_id":       role.Owner,
+				"prerequisites":  prereqs,
+				"max_members":    role.MaxMembers,
+			}).Error; err != nil {
+			return nil, err
+		}
+		if createdRoles[key] {
+			continue
+		}
+		done = append(done, PlanChange{Action: PlanUpdate, Kind: "role", Name: key.String(), ID: existing.ID})
+	}
+
+	// Grants
+	for _, role := range policy.Roles {
+		key := roleKey{Department: role.Department, Name: role.Name}
+		for _, g := range role.Grants {
+			gk := policyGrantKey(key, g)
+			if _, ok := s.grants[gk]; ok {
+				continue
+			}
+			grant := ScopedPermission{
+				RoleID:          s.roles[key].ID,
+				PermissionID:    s.permissions[g.Permission].ID,
+				EmployeeID:      g.Employee,
+				ExactDepartment: g.ExactDepartment,
+				ScopeKind:       g.Relative,
+				ResourceType:    g.ResourceType,
+				ResourceID:      g.ResourceID,
+			}
+			if g.Department != "" {
+				id := s.departments[g.Department].ID
+				grant.DepartmentID = &id
+			}
+			if err := tx.Create(&grant).Error; err != nil {
+				return nil, err
+			}
+			done = append(done, PlanChange{Action: PlanCreate, Kind: "grant", Name: gk.String(), ID: grant.ID})
+		}
+	}
+
+	if !opts.Prune {
+		return done, nil
+	}
+
+	// Prune from the leaves up: grants, roles, permissions, departments
+	pruned, err := planChanges(tx, policy, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range pruned {
+		if c.Action != PlanDelete {
+			return nil, fmt.Errorf("policy apply left %s %s unreconciled", c.Kind, c.Name)
+		}
+		var model interface{}
+		switch c.Kind {
+		case "grant":
+			model = &ScopedPermission{}
+		case "role":
+			model = &Role{}
+			details, err := pruneRoleReferences(tx, c.ID)
+			if err != nil {
+				return nil, err
+			}
+			if details != "" {
+				if c.Details != "" {
+					details = c.Details + "; " + details
+				}
+				c.Details = details
+			}
+		case "permission":
+			model = &Permission{}
+		case "department":
+			model = &Department{}
+		}
+		if err := tx.Delete(model, c.ID).Error; err != nil {
+			return nil, err
+		}
+		done = append(done, c)
+	}
+	return done, nil
+}
+
+// pruneRoleReferences removes what refers to a role about to be pruned: its
+// assignments and group roles, and its place in other roles' prerequisites
+// and in separation-of-duties constraints. It describes what it removed.
+func pruneRoleReferences(tx *gorm.DB, roleID uint) (string, error) {
+	var parts []string
+	res := tx.Where("role_id = ?", roleID).Delete(&EmployeeRole{})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d assignments", res.RowsAffected))
+	}
+	res = tx.Where("role_id = ?", roleID).Delete(&GroupRole{})
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d group roles", res.RowsAffected))
+	}
+
+	var roles []Role
+	if err := tx.Select("id", "prerequisites").Find(&roles).Error; err != nil {
+		return "", err
+	}
+	required := 0
+	for _, role := range roles {
+		if !containsID(role.Prerequisites, roleID) {
+			continue
+		}
+		if err := tx.Model(&Role{}).Where("id = ?", role.ID).
+			Update("prerequisites", withoutID(role.Prerequisites, roleID)).Error; err != nil {
+			return "", err
+		}
+		required++
+	}
+	if required > 0 {
+		parts = append(parts, fmt.Sprintf("dropped from the prerequisites of %d roles", required))
+	}
+
+	var constraints []SoDConstraint
+	if err := tx.Find(&constraints).Error; err != nil {
+		return "", err
+	}
+	constrained := 0
+	for _, c := range constraints {
+		if !containsID(c.RoleIDs, roleID) {
+			continue
+		}
+		if err := tx.Model(&SoDConstraint{}).Where("id = ?", c.ID).
+			Update("role_ids", withoutID(c.RoleIDs, roleID)).Error; err != nil {
+			return "", err
+		}
+		constrained++
+	}
+	if constrained > 0 {
+		parts = append(parts, fmt.Sprintf("dropped from %d constraints", constrained))
+	}
+
+	return strings.Join(parts, "; "), nil
+}
+
+// withoutID returns ids without id.
+func withoutID(ids IDList, id uint) IDList {
+	kept := make(IDList, 0, len(ids))
+	for _, item := range ids {
+		if item != id {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// ExportPolicy describes the current departments, permissions, roles and
+// grants as a policy. Roles in deleted departments, and grants of deleted
+// permissions or in deleted departments, are left out as they have no
+// effect. Two roles with the same name in the same department cannot be told
+// apart by natural key, so they fail the export with ErrInvalidInput.
+func (r *RBAC) ExportPolicy() (*Policy, error) {
+	return exportPolicy(r.db)
+}
+
+// exportPolicy builds a policy from the database.
+func exportPolicy(db *gorm.DB) (*Policy, error) {
+	s, err := loadPolicyState(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.duplicates) > 0 {
+		role := s.duplicates[0]
+		return nil, fmt.Errorf("%w: role %s is not unique", ErrInvalidInput,
+			roleKey{Department: s.deptName(role.DepartmentID), Name: role.Name})
+	}
+
+	policy := &Policy{Version: PolicyVersion}
+	for _, name := range sortedKeys(s.departments) {
+		dept := s.departments[name]
+		pd := PolicyDepartment{Name: name}
+		if dept.ParentDepartmentID != nil {
+			pd.Parent = s.deptNames[*dept.ParentDepartmentID] // Empty if the parent was deleted
+		}
+		policy.Departments = append(policy.Departments, pd)
+	}
+	for _, name := range sortedKeys(s.permissions) {
+		perm := s.permissions[name]
+		policy.Permissions = append(policy.Permissions, PolicyPermission{Name: name, Global: perm.IsGlobal, Sensitive: perm.Sensitive})
+	}
+
+	grantsByRole := make(map[uint][]PolicyGrant)
+	for _, gk := range s.grantOrder {
+		g := s.grants[gk]
+		if _, ok := s.permNames[g.PermissionID]; !ok {
+			continue
+		}
+		pg := PolicyGrant{
+			Permission:      s.permNames[g.PermissionID],
+			ExactDepartment: g.ExactDepartment,
+			Employee:        g.EmployeeID,
+			Relative:        g.ScopeKind,
+			ResourceType:    g.ResourceType,
+			ResourceID:      g.ResourceID,
+		}
+		if g.DepartmentID != nil {
+			name, ok := s.deptNames[*g.DepartmentID]
+			if !ok {
+				continue
+			}
+			pg.Department = name
+		}
+		if policyGrantKey(gk.Role, pg) != gk {
+			continue // An exact duplicate of an earlier grant
+		}
+		grantsByRole[g.RoleID] = append(grantsByRole[g.RoleID], pg)
+	}
+
+	var roles []Role
+	for key, role := range s.roles {
+		if _, ok := s.departments[key.Department]; ok {
+			roles = append(roles, role)
+		}
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+	for _, role := range roles {
+		key := s.roleKeys[role.ID]
+		pr := PolicyRole{Name: key.Name, Department: key.Department, Global: role.IsGlobal, Owner: role.OwnerID,
+			MaxMembers: role.MaxMembers, Grants: grantsByRole[role.ID]}
+		for _, prereqID := range role.Prerequisites {
+			prereq, ok := s.roleKeys[prereqID]
+			if _, live := s.departments[prereq.Department]; !ok || !live {
+				continue // Deleted roles no longer constrain assignments
+			}
+			ref := PolicyRoleRef{Name: prereq.Name}
+			if prereq.Department != key.Department {
+				ref.Department = prereq.Department
+			}
+			pr.Prerequisites = append(pr.Prerequisites, ref)
+		}
+		if role.ParentRoleID != nil {
+			if parent, ok := s.roleKeys[*role.ParentRoleID]; ok {
+				if _, live := s.departments[parent.Department]; live {
+					pr.Parent = parent.Name
+					if parent.Department != key.Department {
+						pr.ParentDepartment = parent.Department
+					}
+				}
+			}
+		}
+		policy.Roles = append(policy.Roles, pr)
+	}
+
+	// Catches what natural keys cannot express, such as a role cycle
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// createOrRestore creates a uniquely named object, reviving the most
+// recently soft-deleted one with the same name instead if there is one, so
+// that it keeps its ID.
+func createOrRestore(tx *gorm.DB, obj interface{}, query string, args ...interface{}) error {
+	var ids []uint
+	if err := tx.Unscoped().Model(obj).Where(query, args...).Where("deleted_at IS NOT NULL").
+		Order("deleted_at DESC").Limit(1).Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return tx.Create(obj).Error
+	}
+	if err := tx.Unscoped().Model(obj).Where("id = ?", ids[0]).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	return tx.First(obj, ids[0]).Error
+}
+
+// samePlanChanges reports whether two plans make the same changes.
+func samePlanChanges(a, b []PlanChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalIDPtr reports whether two optional IDs are equal.
+func equalIDPtr(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// parentDetails describes a parent for a plan change.
+func parentDetails(parent string) string {
+	if parent == "" {
+		return ""
+	}
+	return "parent " + parent
+}
+
+// permissionFlags describes a permission's flags for a plan change.
+func permissionFlags(global, sensitive bool) string {
+	var flags []string
+	if global {
+		flags = append(flags, "global")
+	}
+	if sensitive {
+		flags = append(flags, "sensitive")
+	}
+	return strings.Join(flags, ", ")
+}
+
+// policyPrerequisiteNames describes a declared role's prerequisites in
+// order, as policyState.prerequisiteNames does.
+func policyPrerequisiteNames(role PolicyRole) string {
+	names := make([]string, 0, len(role.Prerequisites))
+	for _, key := range role.prerequisiteKeys() {
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// idString formats an optional ID.
+func idString(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d", *id)
+}
+
+// roleKeyString formats an optional role key.
+func roleKeyString(k roleKey) string {
+	if k.Name == "" {
+		return ""
+	}
+	return k.String()
+}
+
+// orNone returns s, or "none" if it is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// sortedKeys returns a map's keys in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,9 +2,12 @@ package rbac
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 )
 
@@ -13,6 +16,43 @@ type Config struct {
 	DB      *gorm.DB
 	Redis   *redis.Client // Optional; nil disables caching
 	AppName string        // For Redis key prefixing
+	Logger  *zap.Logger   // Optional; nil disables logging
+
+	// StaleDecisionTTL is how long the last known decision for a check may be
+	// served while the database is unavailable. Zero disables stale serving.
+	StaleDecisionTTL time.Duration
+
+	// RedisFailureThreshold is the number of consecutive Redis errors that
+	// open the circuit breaker (default 5).
+	RedisFailureThreshold int
+	// RedisBreakerCooldown is how long the breaker stays open before Redis
+	// is tried again (default 30s).
+	RedisBreakerCooldown time.Duration
+
+	// LegacyRoleScope keeps the behaviour from before role departments were
+	// enforced: non-global roles apply in every department, and global roles
+	// ignore grant scopes. Set it while migrating existing deployments.
+	LegacyRoleScope bool
+
+	// SubordinateStrategy selects how subordinates and direct reports are
+	// derived: from the role hierarchy (default), from employees' managers,
+	// or from both at once.
+	SubordinateStrategy SubordinateStrategy
+
+	// PrerequisiteRemoval selects what happens when an employee loses a role
+	// that another of their roles requires: the removal is blocked (default),
+	// or the dependent assignments are removed along with it.
+	PrerequisiteRemoval PrerequisitePolicy
+
+	// BreakGlassRoleID is the emergency role granted by ActivateBreakGlass.
+	// Zero disables break-glass access.
+	BreakGlassRoleID uint
+	// BreakGlassWindow is how long break-glass access lasts (default 1h).
+	BreakGlassWindow time.Duration
+
+	// AlertHook, if set, is called synchronously with every high-severity
+	// audit entry, such as break-glass activation. It must not block.
+	AlertHook func(AuditLog)
 }
 
 // RBAC is the main struct for the RBAC system.
@@ -20,8 +60,29 @@ type RBAC struct {
 	db      *gorm.DB
 	redis   *redis.Client
 	appName string
+	logger  *zap.Logger
 	ctx     context.Context
 	cancel  context.CancelFunc
+
+	flight   singleflight.Group // Coalesces identical in-flight checks
+	breaker  *circuitBreaker    // Guards Redis calls
+	staleTTL time.Duration
+	stale    *decisionMemo // Last known decisions for stale serving
+	activity *activityLog  // When employees' activity was last recorded
+
+	legacyRoleScope     bool
+	subordinateStrategy SubordinateStrategy
+	prerequisiteRemoval PrerequisitePolicy
+
+	breakGlassRoleID uint
+	breakGlassWindow time.Duration
+	alertHook        func(AuditLog)
+
+	resourceMu      sync.RWMutex
+	resourceParents map[string]ResourceParentFunc // Registered resource hierarchies
+
+	namespaceMu sync.RWMutex
+	namespaces  map[string]NamespaceConfig // Registered relation namespaces
 }
 
 // Init initializes the RBAC system with the provided configuration.
@@ -29,16 +90,44 @@ func Init(config Config) *RBAC {
 	// Create a default context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 
+	logger := config.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	breakGlassWindow := config.BreakGlassWindow
+	if breakGlassWindow <= 0 {
+		breakGlassWindow = time.Hour
+	}
+
 	rbac := &RBAC{
-		db:      config.DB,
-		redis:   config.Redis,
-		appName: config.AppName,
-		ctx:     ctx,
-		cancel:  cancel,
+		db:       config.DB,
+		redis:    config.Redis,
+		appName:  config.AppName,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+		breaker:  newCircuitBreaker(config.RedisFailureThreshold, config.RedisBreakerCooldown),
+		staleTTL: config.StaleDecisionTTL,
+		stale:    newDecisionMemo(),
+		activity: newActivityLog(),
+
+		legacyRoleScope:     config.LegacyRoleScope,
+		subordinateStrategy: config.SubordinateStrategy,
+		prerequisiteRemoval: config.PrerequisiteRemoval,
+
+		breakGlassRoleID: config.BreakGlassRoleID,
+		breakGlassWindow: breakGlassWindow,
+		alertHook:        config.AlertHook,
 	}
 
 	// Ensure PostgreSQL-specific settings (if DB not already initialized)
 	if config.DB.Dialector.Name() == "postgres" {
+		// Names are unique among live rows only; see migrateNameConstraints
+		if err := rbac.migrateNameConstraints(); err != nil {
+			panic("failed to migrate name constraints: " + err.Error())
+		}
+
 		// GORM automatically handles PostgreSQL schema creation
 		// Run migrations for all entities
 		err := rbac.db.AutoMigrate(
@@ -46,7 +135,26 @@ func Init(config Config) *RBAC {
 			&Role{},
 			&Permission{},
 			&EmployeeRole{},
+			&Group{},
+			&GroupMember{},
+			&GroupRole{},
 			&ScopedPermission{},
+			&RelationTuple{},
+			&Employee{},
+			&APIKey{},
+			&Delegation{},
+			&ImpersonationSession{},
+			&BreakGlassGrant{},
+			&AccessRequest{},
+			&SoDConstraint{},
+			&CertificationCampaign{},
+			&CertificationItem{},
+			&Snapshot{},
+			&EmployeeRoleHistory{},
+			&ScopedPermissionHistory{},
+			&RoleHistory{},
+			&GroupMemberHistory{},
+			&GroupRoleHistory{},
 			&AuditLog{},
 		)
 		if err != nil {
@@ -54,6 +162,11 @@ func Init(config Config) *RBAC {
 		}
 	}
 
+	// Record the history of assignments, grants and role parents once its tables exist
+	if err := rbac.registerHistory(); err != nil {
+		panic("failed to record history: " + err.Error())
+	}
+
 	return rbac
 }
 
@@ -0,0 +1,531 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EmployeeNamespace is the subject namespace for employees in relation tuples.
+const EmployeeNamespace = "employee"
+
+// ObjectRef identifies an object in a relation namespace, such as document:42.
+type ObjectRef struct {
+	Namespace string
+	ID        string
+}
+
+// String formats the reference as "<namespace>:<id>".
+func (o ObjectRef) String() string {
+	return o.Namespace + ":" + o.ID
+}
+
+// SubjectRef identifies the subject of a relation tuple. With Relation set it
+// is a userset, such as team:3#member (every member of team 3).
+type SubjectRef struct {
+	Namespace string
+	ID        string
+	Relation  string
+}
+
+// EmployeeSubject returns the subject reference for an employee.
+func EmployeeSubject(empID uint) SubjectRef {
+	return SubjectRef{Namespace: EmployeeNamespace, ID: strconv.FormatUint(uint64(empID), 10)}
+}
+
+// String formats the reference as "<namespace>:<id>" or "<namespace>:<id>#<relation>".
+func (s SubjectRef) String() string {
+	if s.Relation == "" {
+		return s.Namespace + ":" + s.ID
+	}
+	return s.Namespace + ":" + s.ID + "#" + s.Relation
+}
+
+// RewriteKind selects how a relation's subjects are computed.
+type RewriteKind int
+
+// Relation rewrite kinds.
+const (
+	RewriteThis            RewriteKind = iota // Subjects written directly for the relation
+	RewriteComputedUserset                    // Subjects of another relation on the same object
+	RewriteTupleToUserset                     // Subjects of a relation on objects reached through a tupleset relation
+	RewriteUnion                              // Subjects of any child rewrite
+	RewriteIntersection                       // Subjects of every child rewrite
+)
+
+// RelationRewrite defines a relation in terms of tuples and other relations.
+type RelationRewrite struct {
+	Kind     RewriteKind
+	Relation string             // ComputedUserset and TupleToUserset: relation to evaluate
+	Tupleset string             // TupleToUserset: relation pointing at the related objects
+	Children []*RelationRewrite // Union and Intersection operands
+}
+
+// This matches subjects written directly for the relation.
+func This() *RelationRewrite {
+	return &RelationRewrite{Kind: RewriteThis}
+}
+
+// ComputedUserset matches subjects of another relation on the same object,
+// e.g. every editor is also a viewer.
+func ComputedUserset(relation string) *RelationRewrite {
+	return &RelationRewrite{Kind: RewriteComputedUserset, Relation: relation}
+}
+
+// TupleToUserset follows the tupleset relation to other objects and matches
+// subjects of relation there, e.g. viewers of a document's parent folder.
+func TupleToUserset(tupleset, relation string) *RelationRewrite {
+	return &RelationRewrite{Kind: RewriteTupleToUserset, Tupleset: tupleset, Relation: relation}
+}
+
+// Union matches subjects of any child rewrite.
+func Union(children ...*RelationRewrite) *RelationRewrite {
+	return &RelationRewrite{Kind: RewriteUnion, Children: children}
+}
+
+// Intersection matches subjects of every child rewrite.
+func Intersection(children ...*RelationRewrite) *RelationRewrite {
+	return &RelationRewrite{Kind: RewriteIntersection, Children: children}
+}
+
+// NamespaceConfig defines the relations of an object namespace. A relation
+// mapped to nil only matches subjects written directly for it.
+type NamespaceConfig struct {
+	Name      string
+	Relations map[string]*RelationRewrite
+}
+
+// RelationTree is the expansion of a relation on an object.
+type RelationTree struct {
+	Kind     RewriteKind
+	Object   ObjectRef
+	Relation string
+	Subjects []SubjectRef    // RewriteThis: subjects written directly
+	Children []*RelationTree // Expanded rewrites and usersets
+}
+
+// RegisterNamespace adds or replaces a namespace configuration. Relations
+// referenced by rewrites on the same namespace must be defined.
+func (r *RBAC) RegisterNamespace(cfg NamespaceConfig) error {
+	if cfg.Name == "" || strings.ContainsAny(cfg.Name, ":#@") || len(cfg.Relations) == 0 {
+		return ErrInvalidInput
+	}
+	for name, rewrite := range cfg.Relations {
+		if name == "" || strings.ContainsAny(name, ":#@") {
+			return ErrInvalidInput
+		}
+		if err := validateRewrite(cfg, rewrite); err != nil {
+			return err
+		}
+	}
+
+	r.namespaceMu.Lock()
+	if r.namespaces == nil {
+		r.namespaces = make(map[string]NamespaceConfig)
+	}
+	r.namespaces[cfg.Name] = cfg
+	r.namespaceMu.Unlock()
+
+	r.invalidateRelationChecks()
+	return nil
+}
+
+// validateRewrite checks that a rewrite only refers to defined relations.
+func validateRewrite(cfg NamespaceConfig, rewrite *RelationRewrite) error {
+	if rewrite == nil {
+		return nil
+	}
+	switch rewrite.Kind {
+	case RewriteThis:
+	case RewriteComputedUserset:
+		if _, ok := cfg.Relations[rewrite.Relation]; !ok {
+			return fmt.Errorf("%w: unknown relation %q in %s", ErrInvalidInput, rewrite.Relation, cfg.Name)
+		}
+	case RewriteTupleToUserset:
+		if _, ok := cfg.Relations[rewrite.Tupleset]; !ok || rewrite.Relation == "" {
+			return fmt.Errorf("%w: unknown tupleset %q in %s", ErrInvalidInput, rewrite.Tupleset, cfg.Name)
+		}
+	case RewriteUnion, RewriteIntersection:
+		if len(rewrite.Children) == 0 {
+			return ErrInvalidInput
+		}
+		for _, child := range rewrite.Children {
+			if err := validateRewrite(cfg, child); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrInvalidInput
+	}
+	return nil
+}
+
+// namespace returns a registered namespace configuration.
+func (r *RBAC) namespace(name string) (NamespaceConfig, bool) {
+	r.namespaceMu.RLock()
+	defer r.namespaceMu.RUnlock()
+	cfg, ok := r.namespaces[name]
+	return cfg, ok
+}
+
+// relationRewrite returns the rewrite for a relation, which must be defined.
+func (r *RBAC) relationRewrite(namespace, relation string) (*RelationRewrite, error) {
+	cfg, ok := r.namespace(namespace)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown namespace %q", ErrNotFound, namespace)
+	}
+	rewrite, ok := cfg.Relations[relation]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown relation %q in %s", ErrNotFound, relation, namespace)
+	}
+	if rewrite == nil {
+		rewrite = This()
+	}
+	return rewrite, nil
+}
+
+// WriteRelation records that subject has relation to object. Writing an
+// existing tuple is a no-op.
+func (r *RBAC) WriteRelation(object ObjectRef, relation string, subject SubjectRef) error {
+	if object.ID == "" || subject.Namespace == "" || subject.ID == "" {
+		return ErrInvalidInput
+	}
+	if _, err := r.relationRewrite(object.Namespace, relation); err != nil {
+		return err
+	}
+	if subject.Relation != "" {
+		if _, err := r.relationRewrite(subject.Namespace, subject.Relation); err != nil {
+			return err
+		}
+	}
+
+	tuple := &RelationTuple{
+		Namespace:        object.Namespace,
+		ObjectID:         object.ID,
+		Relation:         relation,
+		SubjectNamespace: subject.Namespace,
+		SubjectID:        subject.ID,
+		SubjectRelation:  subject.Relation,
+	}
+	if err := r.db.Where(tuple).Where("subject_relation = ?", subject.Relation).FirstOrCreate(tuple).Error; err != nil {
+		return err
+	}
+
+	r.invalidateRelationChecks()
+	r.logAudit(0, "write_relation", "relation_tuple", tuple.ID, "Wrote relation: "+tupleString(object, relation, subject))
+	return nil
+}
+
+// DeleteRelation soft-deletes a relation tuple.
+func (r *RBAC) DeleteRelation(object ObjectRef, relation string, subject SubjectRef) error {
+	var tuple RelationTuple
+	if err := r.db.Where(&RelationTuple{
+		Namespace:        object.Namespace,
+		ObjectID:         object.ID,
+		Relation:         relation,
+		SubjectNamespace: subject.Namespace,
+		SubjectID:        subject.ID,
+	}).Where("subject_relation = ?", subject.Relation).First(&tuple).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if err := r.db.Delete(&tuple).Error; err != nil {
+		return err
+	}
+
+	r.invalidateRelationChecks()
+	r.logAudit(0, "delete_relation", "relation_tuple", tuple.ID, "Deleted relation: "+tupleString(object, relation, subject))
+	return nil
+}
+
+// ListRelations retrieves the tuples written for a relation on an object.
+func (r *RBAC) ListRelations(object ObjectRef, relation string) ([]RelationTuple, error) {
+	var tuples []RelationTuple
+	if err := r.db.Where("namespace = ? AND object_id = ? AND relation = ?", object.Namespace, object.ID, relation).
+		Find(&tuples).Error; err != nil {
+		return nil, err
+	}
+	return tuples, nil
+}
+
+// CheckRelation reports whether subject has relation to object, following
+// the namespace's rewrites. Results are cached until the next tuple write.
+func (r *RBAC) CheckRelation(object ObjectRef, relation string, subject SubjectRef) (bool, error) {
+	if object.ID == "" || subject.Namespace == "" || subject.ID == "" {
+		return false, ErrInvalidInput
+	}
+
+	key := r.appName + ":rebac:" + tupleString(object, relation, subject)
+	if r.redis != nil {
+		var val string
+		err := r.withRedis(func() (err error) {
+			val, err = r.redis.Get(r.ctx, key).Result()
+			return err
+		})
+		if err == nil {
+			return val == "true", nil
+		}
+	}
+
+	v, err, _ := r.flight.Do(key, func() (interface{}, error) {
+		return r.checkRelation(object, relation, subject, 0)
+	})
+	if err != nil {
+		return false, err
+	}
+	allowed := v.(bool)
+
+	if r.redis != nil {
+		r.withRedis(func() error {
+			return r.redis.Set(r.ctx, key, strconv.FormatBool(allowed), relationCacheTTL).Err()
+		})
+	}
+	return allowed, nil
+}
+
+// checkRelation evaluates a relation's rewrite for a subject.
+func (r *RBAC) checkRelation(object ObjectRef, relation string, subject SubjectRef, depth int) (bool, error) {
+	if depth > maxHierarchyDepth {
+		return false, nil
+	}
+	rewrite, err := r.relationRewrite(object.Namespace, relation)
+	if err != nil {
+		return false, err
+	}
+	return r.checkRewrite(object, relation, rewrite, subject, depth)
+}
+
+// checkRewrite evaluates one rewrite node for a subject.
+func (r *RBAC) checkRewrite(object ObjectRef, relation string, rewrite *RelationRewrite, subject SubjectRef, depth int) (bool, error) {
+	switch rewrite.Kind {
+	case RewriteThis:
+		// The subject itself (or the exact userset) may be written directly
+		if object.Namespace == subject.Namespace && object.ID == subject.ID && relation == subject.Relation {
+			return true, nil
+		}
+		tuples, err := r.ListRelations(object, relation)
+		if err != nil {
+			return false, dbError(err)
+		}
+		for _, t := range tuples {
+			if t.SubjectNamespace == subject.Namespace && t.SubjectID == subject.ID && t.SubjectRelation == subject.Relation {
+				return true, nil
+			}
+		}
+		for _, t := range tuples {
+			if t.SubjectRelation == "" {
+				continue
+			}
+			ok, err := r.checkRelation(ObjectRef{Namespace: t.SubjectNamespace, ID: t.SubjectID}, t.SubjectRelation, subject, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+
+	case RewriteComputedUserset:
+		return r.checkRelation(object, rewrite.Relation, subject, depth+1)
+
+	case RewriteTupleToUserset:
+		tuples, err := r.ListRelations(object, rewrite.Tupleset)
+		if err != nil {
+			return false, dbError(err)
+		}
+		for _, t := range tuples {
+			related := ObjectRef{Namespace: t.SubjectNamespace, ID: t.SubjectID}
+			if _, ok := r.namespace(related.Namespace); !ok {
+				continue
+			}
+			ok, err := r.checkRelation(related, rewrite.Relation, subject, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+
+	case RewriteUnion:
+		for _, child := range rewrite.Children {
+			ok, err := r.checkRewrite(object, relation, child, subject, depth)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+
+	case RewriteIntersection:
+		for _, child := range rewrite.Children {
+			ok, err := r.checkRewrite(object, relation, child, subject, depth)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// ExpandRelation returns the tree of subjects that have relation to object,
+// expanding rewrites and usersets.
+func (r *RBAC) ExpandRelation(object ObjectRef, relation string) (*RelationTree, error) {
+	if object.ID == "" {
+		return nil, ErrInvalidInput
+	}
+	return r.expandRelation(object, relation, 0)
+}
+
+// expandRelation expands a relation's rewrite on an object.
+func (r *RBAC) expandRelation(object ObjectRef, relation string, depth int) (*RelationTree, error) {
+	rewrite, err := r.relationRewrite(object.Namespace, relation)
+	if err != nil {
+		return nil, err
+	}
+	return r.expandRewrite(object, relation, rewrite, depth)
+}
+
+// expandRewrite expands one rewrite node.
+func (r *RBAC) expandRewrite(object ObjectRef, relation string, rewrite *RelationRewrite, depth int) (*RelationTree, error) {
+	tree := &RelationTree{Kind: rewrite.Kind, Object: object, Relation: relation}
+	if depth > maxHierarchyDepth {
+		return tree, nil
+	}
+
+	switch rewrite.Kind {
+	case RewriteThis:
+		tuples, err := r.ListRelations(object, relation)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tuples {
+			subject := SubjectRef{Namespace: t.SubjectNamespace, ID: t.SubjectID, Relation: t.SubjectRelation}
+			tree.Subjects = append(tree.Subjects, subject)
+			if subject.Relation != "" {
+				child, err := r.expandRelation(ObjectRef{Namespace: subject.Namespace, ID: subject.ID}, subject.Relation, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				tree.Children = append(tree.Children, child)
+			}
+		}
+
+	case RewriteComputedUserset:
+		child, err := r.expandRelation(object, rewrite.Relation, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		tree.Children = append(tree.Children, child)
+
+	case RewriteTupleToUserset:
+		tuples, err := r.ListRelations(object, rewrite.Tupleset)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tuples {
+			related := ObjectRef{Namespace: t.SubjectNamespace, ID: t.SubjectID}
+			if _, ok := r.namespace(related.Namespace); !ok {
+				continue
+			}
+			child, err := r.expandRelation(related, rewrite.Relation, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, child)
+		}
+
+	case RewriteUnion, RewriteIntersection:
+		for _, c := range rewrite.Children {
+			child, err := r.expandRewrite(object, relation, c, depth)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, child)
+		}
+	}
+	return tree, nil
+}
+
+// ListObjects returns the IDs of objects in a namespace to which subject has
+// relation. Candidates are found by walking tuples back from the subject:
+// only objects connected to it through some chain of tuples can match. Each
+// candidate is then checked through CheckRelation, so results are cached.
+func (r *RBAC) ListObjects(namespace, relation string, subject SubjectRef) ([]string, error) {
+	if _, err := r.relationRewrite(namespace, relation); err != nil {
+		return nil, err
+	}
+	if subject.Namespace == "" || subject.ID == "" {
+		return nil, ErrInvalidInput
+	}
+
+	candidates, err := r.relatedObjects(namespace, ObjectRef{Namespace: subject.Namespace, ID: subject.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	var objectIDs []string
+	for _, id := range candidates {
+		ok, err := r.CheckRelation(ObjectRef{Namespace: namespace, ID: id}, relation, subject)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			objectIDs = append(objectIDs, id)
+		}
+	}
+	sort.Strings(objectIDs)
+	return objectIDs, nil
+}
+
+// relatedObjects returns the IDs of objects in namespace that reach start
+// through a chain of tuples, whatever their relations, up to the depth a
+// check follows. The start itself counts, as a userset can name its own
+// object.
+func (r *RBAC) relatedObjects(namespace string, start ObjectRef) ([]string, error) {
+	seen := map[ObjectRef]bool{start: true}
+	frontier := []ObjectRef{start}
+	for depth := 0; depth <= maxHierarchyDepth && len(frontier) > 0; depth++ {
+		byNamespace := make(map[string][]string)
+		for _, o := range frontier {
+			byNamespace[o.Namespace] = append(byNamespace[o.Namespace], o.ID)
+		}
+		frontier = nil
+		for _, ns := range sortedKeys(byNamespace) {
+			var tuples []RelationTuple
+			if err := r.db.Select("namespace", "object_id").
+				Where("subject_namespace = ? AND subject_id IN ?", ns, byNamespace[ns]).
+				Find(&tuples).Error; err != nil {
+				return nil, err
+			}
+			for _, t := range tuples {
+				o := ObjectRef{Namespace: t.Namespace, ID: t.ObjectID}
+				if !seen[o] {
+					seen[o] = true
+					frontier = append(frontier, o)
+				}
+			}
+		}
+	}
+
+	var ids []string
+	for o := range seen {
+		if o.Namespace == namespace {
+			ids = append(ids, o.ID)
+		}
+	}
+	return ids, nil
+}
+
+// invalidateRelationChecks drops cached relation checks. Any tuple or
+// namespace change can affect checks on other objects.
+func (r *RBAC) invalidateRelationChecks() {
+	if r.redis == nil {
+		return
+	}
+	r.withRedis(func() error {
+		return r.deleteKeys(r.appName + ":rebac:*")
+	})
+}
+
+// tupleString formats a tuple as "<namespace>:<id>#<relation>@<subject>".
+func tupleString(object ObjectRef, relation string, subject SubjectRef) string {
+	return object.String() + "#" + relation + "@" + subject.String()
+}
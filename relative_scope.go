@@ -0,0 +1,243 @@
+package rbac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScopeKind scopes a grant relative to the employee being checked, rather
+// than to a fixed department or employee.
+type ScopeKind string
+
+// Relative scope kinds.
+const (
+	ScopeSelf          ScopeKind = "self"           // The target employee is the caller
+	ScopeDirectReports ScopeKind = "direct_reports" // The target employee reports directly to the caller
+	ScopeSubordinates  ScopeKind = "subordinates"   // The target employee is one of the caller's subordinates
+	ScopeOwnDepartment ScopeKind = "own_department" // The department is one of the caller's departments
+)
+
+// relationCacheTTL bounds how long resolved relation sets are cached.
+const relationCacheTTL = 5 * time.Minute
+
+// valid reports whether k is a known relative scope kind.
+func (k ScopeKind) valid() bool {
+	switch k {
+	case ScopeSelf, ScopeDirectReports, ScopeSubordinates, ScopeOwnDepartment:
+		return true
+	}
+	return false
+}
+
+// AddRelativeScopedPermission grants a permission to a role, scoped relative
+// to whoever is being checked (for example "their subordinates").
+func (r *RBAC) AddRelativeScopedPermission(roleID, permID uint, kind ScopeKind) error {
+	if !kind.valid() {
+		return ErrInvalidInput
+	}
+	return r.addScopedPermission(&ScopedPermission{
+		RoleID:       roleID,
+		PermissionID: permID,
+		ScopeKind:    kind,
+	})
+}
+
+// relativeGrantMatches reports whether a grant covers the scope of a check.
+// Grants without a relative scope always match here, as their department
+// and employee scope has already been applied in SQL. As with absolute
+// scopes, a check that does not name a target employee or department is
+// covered by the corresponding relative grant.
+func (r *RBAC) relativeGrantMatches(grant *ScopedPermission, scope *checkScope) (bool, error) {
+	switch grant.ScopeKind {
+	case "":
+		return true, nil
+	case ScopeSelf:
+		return scope.targetEmpID == nil || *scope.targetEmpID == scope.empID, nil
+	case ScopeDirectReports, ScopeSubordinates:
+		if scope.targetEmpID == nil {
+			return true, nil
+		}
+		related, err := r.callerRelations(scope, grant.ScopeKind)
+		if err != nil {
+			return false, err
+		}
+		return *scope.targetEmpID != scope.empID && related[*scope.targetEmpID], nil
+	case ScopeOwnDepartment:
+		if scope.deptID == nil {
+			return true, nil
+		}
+		depts, err := r.callerRelations(scope, ScopeOwnDepartment)
+		if err != nil {
+			return false, err
+		}
+		if depts[*scope.deptID] {
+			return true, nil
+		}
+		if !grant.ExactDepartment {
+			for _, ancestorID := range scope.deptAncestors {
+				if depts[ancestorID] {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+// callerRelations resolves one kind of relative scope for the caller once
+// per check, going through the relation cache.
+func (r *RBAC) callerRelations(scope *checkScope, kind ScopeKind) (map[uint]bool, error) {
+	scope.usedRelative = true
+	if set, ok := scope.relations[kind]; ok {
+		return set, nil
+	}
+
+	ids, err := r.cachedRelation(kind, scope.empID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	if scope.relations == nil {
+		scope.relations = make(map[ScopeKind]map[uint]bool)
+	}
+	scope.relations[kind] = set
+	return set, nil
+}
+
+// cachedRelation returns the IDs related to an employee for a relative scope
+// kind, reading through Redis when it is available.
+func (r *RBAC) cachedRelation(kind ScopeKind, empID uint) ([]uint, error) {
+	key := fmt.Sprintf("%s:rel:%s:%d", r.appName, kind, empID)
+	if r.redis != nil {
+		var val string
+		err := r.withRedis(func() (err error) {
+			val, err = r.redis.Get(r.ctx, key).Result()
+			return err
+		})
+		if err == nil {
+			return parseIDList(val), nil
+		}
+	}
+
+	var ids []uint
+	var err error
+	switch kind {
+	case ScopeDirectReports:
+		ids, err = r.getDirectReportIDs(empID)
+	case ScopeSubordinates:
+		ids, err = r.GetSubordinateIDs(empID)
+	case ScopeOwnDepartment:
+		var assignments map[uint][]uint
+		if assignments, err = r.roleAssignments([]uint{empID}); err == nil {
+			err = r.db.Model(&Role{}).
+				Where("id IN ?", assignments[empID]).
+				Distinct("department_id").
+				Pluck("department_id", &ids).Error
+		}
+		// The employee's home department counts as well
+		if err == nil {
+			var home []uint
+			err = r.db.Model(&Employee{}).
+				Where("id = ? AND department_id IS NOT NULL", empID).
+				Pluck("department_id", &home).Error
+			ids = append(ids, home...)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.redis != nil {
+		r.withRedis(func() error {
+			return r.redis.Set(r.ctx, key, formatIDList(ids), relationCacheTTL).Err()
+		})
+	}
+	return ids, nil
+}
+
+// getDirectReportIDs fetches the caller's direct reports according to the
+// configured SubordinateStrategy.
+func (r *RBAC) getDirectReportIDs(empID uint) ([]uint, error) {
+	switch r.subordinateStrategy {
+	case SubordinatesByReportingLine:
+		return r.GetDirectReports(empID)
+	case SubordinatesByBoth:
+		byRole, err := r.roleDirectReportIDs(empID)
+		if err != nil {
+			return nil, err
+		}
+		byLine, err := r.GetDirectReports(empID)
+		if err != nil {
+			return nil, err
+		}
+		return intersectIDs(byRole, byLine), nil
+	}
+	return r.roleDirectReportIDs(empID)
+}
+
+// roleDirectReportIDs fetches employees holding a role whose parent is one
+// of the caller's roles.
+func (r *RBAC) roleDirectReportIDs(empID uint) ([]uint, error) {
+	assignments, err := r.roleAssignments([]uint{empID})
+	if err != nil {
+		return nil, err
+	}
+
+	var childRoleIDs []uint
+	if err := r.db.Model(&Role{}).Where("parent_role_id IN ?", assignments[empID]).Pluck("id", &childRoleIDs).Error; err != nil {
+		return nil, err
+	}
+
+	reports, err := r.employeesWithRoles(childRoleIDs)
+	if err != nil {
+		return nil, err
+	}
+	empIDs := reports[:0]
+	for _, id := range reports {
+		if id != empID {
+			empIDs = append(empIDs, id)
+		}
+	}
+	return empIDs, nil
+}
+
+// invalidateRelations drops cached relation sets. Any assignment or role
+// change can alter other employees' reports and subordinates.
+func (r *RBAC) invalidateRelations() {
+	if r.redis == nil {
+		return
+	}
+	r.withRedis(func() error {
+		return r.deleteKeys(r.appName + ":rel:*")
+	})
+}
+
+// formatIDList encodes IDs for the relation cache.
+func formatIDList(ids []uint) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseIDList decodes IDs from the relation cache.
+func parseIDList(val string) []uint {
+	if val == "" {
+		return nil
+	}
+	parts := strings.Split(val, ",")
+	ids := make([]uint, 0, len(parts))
+	for _, part := range parts {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
@@ -0,0 +1,228 @@
+package rbac
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// circuitBreaker stops calls to a failing dependency for a cooldown period.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	tripped   bool
+	probing   bool // A trial call is in flight while half-open
+}
+
+// newCircuitBreaker creates a breaker, applying defaults for zero values.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may be attempted. Once the cooldown has
+// elapsed a single trial call is let through (half-open); others are refused
+// until its success or failure is recorded.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return false
+	}
+	if !b.tripped {
+		return true
+	}
+	if b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success records a successful call and reports whether the breaker was
+// previously tripped, meaning the dependency has just recovered.
+func (b *circuitBreaker) success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recovered := b.tripped
+	b.failures = 0
+	b.tripped = false
+	b.probing = false
+	b.openUntil = time.Time{}
+	return recovered
+}
+
+// failure records a failed call and reports whether it opened the breaker.
+func (b *circuitBreaker) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	b.failures++
+	if b.failures < b.threshold {
+		return false
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	opened := !b.tripped
+	b.tripped = true
+	return opened
+}
+
+// isOpen reports whether calls are currently being short-circuited.
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.openUntil) || b.probing
+}
+
+var errBreakerOpen = errors.New("redis circuit breaker open")
+
+// withRedis runs fn against Redis unless the circuit breaker is open.
+func (r *RBAC) withRedis(fn func() error) error {
+	if !r.breaker.allow() {
+		return errBreakerOpen
+	}
+
+	err := fn()
+	if err != nil && err != redis.Nil {
+		if r.breaker.failure() {
+			r.logger.Warn("redis circuit breaker opened", zap.Error(err))
+		}
+		return err
+	}
+
+	if r.breaker.success() {
+		// Invalidations may have been missed while Redis was unreachable.
+		r.logger.Info("redis circuit breaker closed; flushing cached decisions")
+		if ferr := r.deleteKeys(r.appName + ":perm:*"); ferr != nil {
+			r.logger.Warn("failed to flush cached decisions", zap.Error(ferr))
+		}
+	}
+	return err
+}
+
+// deleteKeys deletes the Redis keys matching pattern. It uses SCAN rather
+// than KEYS, so a large cache does not block Redis, and deletes in batches.
+// Callers run it through withRedis.
+func (r *RBAC) deleteKeys(pattern string) error {
+	iter := r.redis.Scan(r.ctx, 0, pattern, 1000).Iterator()
+	var keys []string
+	for iter.Next(r.ctx) {
+		if keys = append(keys, iter.Val()); len(keys) == 1000 {
+			if err := r.redis.Del(r.ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return r.redis.Del(r.ctx, keys...).Err()
+	}
+	return nil
+}
+
+// memoEntry is a decision remembered for stale serving.
+type memoEntry struct {
+	allowed bool
+	at      time.Time
+}
+
+// decisionMemo keeps the last known decision per check key in process memory,
+// so it remains available when both Redis and the database are not.
+type decisionMemo struct {
+	mu      sync.Mutex
+	entries map[string]memoEntry
+}
+
+func newDecisionMemo() *decisionMemo {
+	return &decisionMemo{entries: make(map[string]memoEntry)}
+}
+
+// remember stores a decision, pruning entries older than ttl every so often.
+func (m *decisionMemo) remember(key string, allowed bool, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if len(m.entries) > 0 && len(m.entries)%1024 == 0 {
+		for k, e := range m.entries {
+			if now.Sub(e.at) > ttl {
+				delete(m.entries, k)
+			}
+		}
+	}
+	m.entries[key] = memoEntry{allowed: allowed, at: now}
+}
+
+// lookup returns the remembered decision if it is younger than ttl.
+func (m *decisionMemo) lookup(key string, ttl time.Duration) (memoEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || time.Since(e.at) > ttl {
+		return memoEntry{}, false
+	}
+	return e, true
+}
+
+// forget drops remembered decisions whose key starts with prefix.
+func (m *decisionMemo) forget(prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k := range m.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.entries, k)
+		}
+	}
+}
+
+// rememberDecision records a freshly evaluated decision for stale serving.
+func (r *RBAC) rememberDecision(key string, allowed bool) {
+	if r.staleTTL > 0 {
+		r.stale.remember(key, allowed, r.staleTTL)
+	}
+}
+
+// serveStale returns the last known decision for key when stale serving is
+// enabled and the decision is recent enough. Each use is logged.
+func (r *RBAC) serveStale(key string, cause error) (bool, bool) {
+	if r.staleTTL <= 0 {
+		return false, false
+	}
+	e, ok := r.stale.lookup(key, r.staleTTL)
+	if !ok {
+		return false, false
+	}
+	r.logger.Warn("serving stale permission decision",
+		zap.String("key", key),
+		zap.Bool("allowed", e.allowed),
+		zap.Duration("age", time.Since(e.at)),
+		zap.Error(cause),
+	)
+	return e.allowed, true
+}
+
+// dbError maps a database error from the check path to a library error.
+func dbError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
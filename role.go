@@ -1,5 +1,11 @@
 package rbac
 
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
 // CreateRole creates a new role in a department with optional parent role.
 func (r *RBAC) CreateRole(name string, deptID uint, parentRoleID *uint, isGlobal bool) (*Role, error) {
 	if name == "" || deptID == 0 {
@@ -74,6 +80,30 @@ func (r *RBAC) UpdateRole(id uint, name string, deptID uint, parentRoleID *uint,
 	return &role, nil
 }
 
+// SetRoleOwner sets or clears the employee who approves access requests for
+// a role.
+func (r *RBAC) SetRoleOwner(id uint, ownerID *uint) error {
+	if id == 0 || (ownerID != nil && *ownerID == 0) {
+		return ErrInvalidInput
+	}
+
+	var role Role
+	if err := r.db.First(&role, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if err := r.db.Model(&role).Update("owner_id", ownerID).Error; err != nil {
+		return err
+	}
+
+	details := "Cleared role owner"
+	if ownerID != nil {
+		details = fmt.Sprintf("Set role owner to employee %d", *ownerID)
+	}
+	r.logAudit(0, "set_role_owner", "role", id, details)
+	return nil
+}
+
 // GetRole retrieves a role by ID.
 func (r *RBAC) GetRole(id uint) (*Role, error) {
 	if id == 0 {
@@ -120,3 +150,50 @@ func (r *RBAC) ListRoles(deptID *uint) ([]Role, error) {
 	}
 	return roles, nil
 }
+
+// RestoreRole undeletes a soft-deleted role. Its department and parent role
+// must not be deleted. Assignments and grants removed along with the role
+// are not restored.
+func (r *RBAC) RestoreRole(id uint) (*Role, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var role Role
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").First(&role, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	var dept Department
+	if err := r.db.First(&dept, role.DepartmentID).Error; err != nil {
+		return nil, fmt.Errorf("%w: department %d is deleted", ErrInvalidInput, role.DepartmentID)
+	}
+	if role.ParentRoleID != nil {
+		var parent Role
+		if err := r.db.First(&parent, *role.ParentRoleID).Error; err != nil {
+			return nil, fmt.Errorf("%w: parent role %d is deleted", ErrInvalidInput, *role.ParentRoleID)
+		}
+	}
+
+	if err := r.db.Unscoped().Model(&role).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	role.DeletedAt = gorm.DeletedAt{}
+
+	r.invalidateCache(0) // Existing assignments of the role take effect again
+	r.logAudit(0, "restore_role", "role", id, "Restored role: "+role.Name)
+	return &role, nil
+}
+
+// ListDeletedRoles retrieves soft-deleted roles, most recently deleted first,
+// optionally filtered by department.
+func (r *RBAC) ListDeletedRoles(deptID *uint) ([]Role, error) {
+	var roles []Role
+	query := r.db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC")
+	if deptID != nil {
+		query = query.Where("department_id = ?", *deptID)
+	}
+	if err := query.Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
@@ -0,0 +1,350 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PrerequisitePolicy selects what happens to roles whose prerequisite is
+// removed from an employee.
+type PrerequisitePolicy string
+
+// Prerequisite removal policies.
+const (
+	// PrerequisiteBlock rejects the removal with ErrPrerequisiteInUse (default).
+	PrerequisiteBlock PrerequisitePolicy = "block"
+	// PrerequisiteCascade removes the dependent assignments as well.
+	PrerequisiteCascade PrerequisitePolicy = "cascade"
+)
+
+// SetRolePrerequisites sets the roles an employee must hold, directly or
+// through a group, before being assigned the role. Existing assignments are
+// not re-checked. Prerequisites that would form a cycle are rejected with
+// ErrInvalidInput, as no one could ever be assigned the roles involved.
+func (r *RBAC) SetRolePrerequisites(id uint, prerequisiteIDs []uint) error {
+	if id == 0 {
+		return ErrInvalidInput
+	}
+	for _, prereqID := range prerequisiteIDs {
+		if prereqID == 0 || prereqID == id {
+			return ErrInvalidInput
+		}
+	}
+
+	var role Role
+	if err := r.db.First(&role, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	var prereqs []Role
+	if len(prerequisiteIDs) > 0 {
+		if err := r.db.Where("id IN ?", prerequisiteIDs).Find(&prereqs).Error; err != nil {
+			return err
+		}
+		if len(prereqs) != len(uniqueIDs(prerequisiteIDs)) {
+			return ErrNotFound
+		}
+	}
+
+	cyclic, err := r.prerequisiteCycle(id, prerequisiteIDs)
+	if err != nil {
+		return err
+	}
+	if cyclic {
+		return ErrInvalidInput
+	}
+
+	if err := r.db.Model(&role).Update("prerequisites", IDList(uniqueIDs(prerequisiteIDs))).Error; err != nil {
+		return err
+	}
+
+	r.logAudit(0, "set_role_prerequisites", "role", id,
+		"Set role prerequisites to ["+formatIDList(prerequisiteIDs)+"]")
+	return nil
+}
+
+// SetRoleMaxMembers caps the number of employees directly assigned the
+// role; zero removes the cap. Lowering the cap below the current count does
+// not remove anyone, but blocks new assignments until the count drops.
+func (r *RBAC) SetRoleMaxMembers(id uint, maxMembers int) error {
+	if id == 0 || maxMembers < 0 {
+		return ErrInvalidInput
+	}
+
+	var role Role
+	if err := r.db.First(&role, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if err := r.db.Model(&role).Update("max_members", maxMembers).Error; err != nil {
+		return err
+	}
+
+	r.logAudit(0, "set_role_max_members", "role", id, fmt.Sprintf("Set role member limit to %d", maxMembers))
+	return nil
+}
+
+// prerequisiteCycle reports whether giving roleID the prerequisites would
+// make it, transitively, a prerequisite of itself.
+func (r *RBAC) prerequisiteCycle(roleID uint, prerequisiteIDs []uint) (bool, error) {
+	var roles []Role
+	if err := r.db.Select("id", "prerequisites").Find(&roles).Error; err != nil {
+		return false, err
+	}
+	requires := make(map[uint][]uint, len(roles))
+	for _, role := range roles {
+		requires[role.ID] = role.Prerequisites
+	}
+	requires[roleID] = prerequisiteIDs
+
+	visited := make(map[uint]bool)
+	stack := append([]uint(nil), prerequisiteIDs...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == roleID {
+			return true, nil
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		stack = append(stack, requires[id]...)
+	}
+	return false, nil
+}
+
+// grantRoles runs grant, which assigns roles directly to employees inside
+// tx, and then enforces the roles' prerequisites and member limits. The
+// roles are locked first, so concurrent assignments of a limited role queue
+// behind each other instead of both seeing a free seat.
+func (r *RBAC) grantRoles(tx *gorm.DB, grants map[uint][]uint, grant func() error) error {
+	var roleIDs []uint
+	for _, ids := range grants {
+		roleIDs = append(roleIDs, ids...)
+	}
+	roleIDs = uniqueIDs(roleIDs)
+	sort.Slice(roleIDs, func(i, j int) bool { return roleIDs[i] < roleIDs[j] })
+
+	var roles []Role
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", roleIDs).Order("id").Find(&roles).Error; err != nil {
+		return err
+	}
+	if len(roles) != len(roleIDs) {
+		return ErrNotFound
+	}
+
+	if err := grant(); err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if role.MaxMembers <= 0 {
+			continue
+		}
+		var count int64
+		if err := tx.Model(&EmployeeRole{}).
+			Where("role_id = ? AND (expires_at IS NULL OR expires_at > ?)", role.ID, time.Now()).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > int64(role.MaxMembers) {
+			return fmt.Errorf("%w: role %s is limited to %d members", ErrRoleFull, role.Name, role.MaxMembers)
+		}
+	}
+
+	empIDs := make([]uint, 0, len(grants))
+	for empID := range grants {
+		empIDs = append(empIDs, empID)
+	}
+	held, err := roleAssignmentsIn(tx, empIDs)
+	if err != nil {
+		return err
+	}
+	byID := make(map[uint]Role, len(roles))
+	for _, role := range roles {
+		byID[role.ID] = role
+	}
+	for empID, ids := range grants {
+		for _, roleID := range ids {
+			for _, prereqID := range byID[roleID].Prerequisites {
+				if !containsID(held[empID], prereqID) {
+					return fmt.Errorf("%w: employee %d needs role %d before %s",
+						ErrMissingPrerequisite, empID, prereqID, byID[roleID].Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// revokeRoles runs revoke, which takes roles away from the employees inside
+// tx, and then applies the configured PrerequisiteRemoval policy to direct
+// assignments whose prerequisites are no longer held. Assignments that were
+// already missing a prerequisite are left alone. Under PrerequisiteCascade
+// the removed dependents are returned so the caller can audit them.
+func (r *RBAC) revokeRoles(tx *gorm.DB, empIDs []uint, revoke func() error) ([]EmployeeRole, error) {
+	before, err := unmetPrerequisites(tx, empIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := revoke(); err != nil {
+		return nil, err
+	}
+
+	var cascaded []EmployeeRole
+	for {
+		after, err := unmetPrerequisites(tx, empIDs)
+		if err != nil {
+			return nil, err
+		}
+		var broken []EmployeeRole
+		for key, er := range after {
+			if _, ok := before[key]; !ok {
+				broken = append(broken, er)
+			}
+		}
+		if len(broken) == 0 {
+			return cascaded, nil
+		}
+		if r.prerequisiteRemoval != PrerequisiteCascade {
+			return nil, fmt.Errorf("%w: employee %d holds role %d, which requires it",
+				ErrPrerequisiteInUse, broken[0].EmployeeID, broken[0].RoleID)
+		}
+		for _, er := range broken {
+			if err := tx.Where("employee_id = ? AND role_id = ?", er.EmployeeID, er.RoleID).
+				Delete(&EmployeeRole{}).Error; err != nil {
+				return nil, err
+			}
+		}
+		// Removing a dependent may in turn break roles that require it
+		cascaded = append(cascaded, broken...)
+	}
+}
+
+// auditCascade records assignments removed by PrerequisiteCascade.
+func (r *RBAC) auditCascade(cascaded []EmployeeRole) {
+	for _, er := range cascaded {
+		r.logAudit(er.EmployeeID, "cascade_remove_role", "employee_role", er.RoleID,
+			"Removed role whose prerequisite was removed")
+	}
+}
+
+// prerequisiteHolders returns the employees with a live direct assignment to
+// a role that has prerequisites. Only their assignments can be left without a
+// prerequisite, so they are the employees revokeRoles needs to watch when a
+// change may take roles away from anyone.
+func prerequisiteHolders(db *gorm.DB) ([]uint, error) {
+	var roles []Role
+	if err := db.Select("id", "prerequisites").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	var dependent []uint
+	for _, role := range roles {
+		if len(role.Prerequisites) > 0 {
+			dependent = append(dependent, role.ID)
+		}
+	}
+	if len(dependent) == 0 {
+		return nil, nil
+	}
+
+	var empIDs []uint
+	if err := db.Model(&EmployeeRole{}).
+		Where("role_id IN ? AND (expires_at IS NULL OR expires_at > ?)", dependent, time.Now()).
+		Distinct().Pluck("employee_id", &empIDs).Error; err != nil {
+		return nil, err
+	}
+	return empIDs, nil
+}
+
+// unmetPrerequisites returns the employees' direct assignments whose role
+// has a prerequisite the employee does not hold, keyed by employee and role.
+func unmetPrerequisites(db *gorm.DB, empIDs []uint) (map[[2]uint]EmployeeRole, error) {
+	unmet := make(map[[2]uint]EmployeeRole)
+	if len(empIDs) == 0 {
+		return unmet, nil
+	}
+
+	var direct []EmployeeRole
+	if err := db.Where("employee_id IN ? AND (expires_at IS NULL OR expires_at > ?)", empIDs, time.Now()).
+		Find(&direct).Error; err != nil {
+		return nil, err
+	}
+	if len(direct) == 0 {
+		return unmet, nil
+	}
+
+	roleIDs := make([]uint, 0, len(direct))
+	for _, er := range direct {
+		roleIDs = append(roleIDs, er.RoleID)
+	}
+	var roles []Role
+	if err := db.Select("id", "prerequisites").Where("id IN ?", uniqueIDs(roleIDs)).Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	requires := make(map[uint][]uint, len(roles))
+	for _, role := range roles {
+		if len(role.Prerequisites) > 0 {
+			requires[role.ID] = role.Prerequisites
+		}
+	}
+	if len(requires) == 0 {
+		return unmet, nil
+	}
+
+	held, err := roleAssignmentsIn(db, empIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, er := range direct {
+		for _, prereqID := range requires[er.RoleID] {
+			if !containsID(held[er.EmployeeID], prereqID) {
+				unmet[[2]uint{er.EmployeeID, er.RoleID}] = er
+				break
+			}
+		}
+	}
+	return unmet, nil
+}
+
+// containsID reports whether ids contains id.
+func containsID(ids []uint, id uint) bool {
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueIDs returns ids without duplicates, in first-seen order.
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// uniqueStrings returns list without duplicates, in first-seen order.
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	unique := make([]string, 0, len(list))
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
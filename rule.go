@@ -0,0 +1,342 @@
+package rbac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rule is a parsed attribute rule such as
+//
+//	location == "Berlin" && level >= 5
+//
+// Rules combine comparisons of an attribute with a string, number or boolean
+// literal using &&, || and !, with parentheses for grouping. A comparison on
+// a missing attribute, or between mismatched types, is false.
+type Rule struct {
+	source string
+	root   ruleNode
+}
+
+// ParseRule parses an attribute rule.
+func ParseRule(source string) (*Rule, error) {
+	p := &ruleParser{src: source}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Rule{source: source, root: root}, nil
+}
+
+// String returns the rule's source text.
+func (r *Rule) String() string {
+	return r.source
+}
+
+// Match evaluates the rule against an attribute bag.
+func (r *Rule) Match(attrs map[string]interface{}) bool {
+	return r.root.eval(attrs)
+}
+
+type ruleNode interface {
+	eval(attrs map[string]interface{}) bool
+}
+
+type andNode struct{ left, right ruleNode }
+
+func (n andNode) eval(attrs map[string]interface{}) bool {
+	return n.left.eval(attrs) && n.right.eval(attrs)
+}
+
+type orNode struct{ left, right ruleNode }
+
+func (n orNode) eval(attrs map[string]interface{}) bool {
+	return n.left.eval(attrs) || n.right.eval(attrs)
+}
+
+type notNode struct{ operand ruleNode }
+
+func (n notNode) eval(attrs map[string]interface{}) bool {
+	return !n.operand.eval(attrs)
+}
+
+type compareNode struct {
+	attr  string
+	op    string
+	value interface{} // string, float64 or bool
+}
+
+func (n compareNode) eval(attrs map[string]interface{}) bool {
+	actual, ok := attrs[n.attr]
+	if !ok || actual == nil {
+		return false
+	}
+
+	switch want := n.value.(type) {
+	case float64:
+		got, ok := toFloat(actual)
+		if !ok {
+			return false
+		}
+		return compareOrdered(got, want, n.op)
+	case string:
+		got, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		return compareOrdered(got, want, n.op)
+	case bool:
+		got, ok := actual.(bool)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case "==":
+			return got == want
+		case "!=":
+			return got != want
+		}
+	}
+	return false
+}
+
+// toFloat converts the numeric types an attribute may hold.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T float64 | string](got, want T, op string) bool {
+	switch op {
+	case "==":
+		return got == want
+	case "!=":
+		return got != want
+	case "<":
+		return got < want
+	case "<=":
+		return got <= want
+	case ">":
+		return got > want
+	case ">=":
+		return got >= want
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// ruleParser is a recursive-descent parser over the rule grammar:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" or ")" | compare
+//	compare = ident op literal
+type ruleParser struct {
+	src string
+	pos int
+	tok token
+	err error
+}
+
+func (p *ruleParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: rule %q: %s", ErrInvalidInput, p.src, fmt.Sprintf(format, args...))
+}
+
+// next advances to the next token.
+func (p *ruleParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF}
+		return
+	}
+
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		p.tok = token{kind: tokLParen, text: "("}
+	case c == ')':
+		p.pos++
+		p.tok = token{kind: tokRParen, text: ")"}
+	case c == '"':
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+			}
+			b.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			p.err = p.errorf("unterminated string")
+			p.tok = token{kind: tokEOF}
+			return
+		}
+		p.pos++
+		p.tok = token{kind: tokString, text: b.String()}
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.pos++
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos]}
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] == '.' ||
+			unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos]}
+	default:
+		for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!"} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += len(op)
+				p.tok = token{kind: tokOp, text: op}
+				return
+			}
+		}
+		p.err = p.errorf("unexpected character %q", c)
+		p.tok = token{kind: tokEOF}
+	}
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && p.tok.text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, p.err
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && p.tok.text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, p.err
+}
+
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	switch {
+	case p.tok.kind == tokOp && p.tok.text == "!":
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	case p.tok.kind == tokLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return inner, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *ruleParser) parseCompare() (ruleNode, error) {
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("expected attribute name, got %q", p.tok.text)
+	}
+	attr := p.tok.text
+	p.next()
+
+	op := p.tok.text
+	switch {
+	case p.tok.kind != tokOp:
+		return nil, p.errorf("expected comparison after %q", attr)
+	case op == "&&" || op == "||" || op == "!":
+		return nil, p.errorf("expected comparison after %q, got %q", attr, op)
+	}
+	p.next()
+
+	var value interface{}
+	switch p.tok.kind {
+	case tokString:
+		value = p.tok.text
+	case tokNumber:
+		n, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.tok.text)
+		}
+		value = n
+	case tokIdent:
+		switch p.tok.text {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		default:
+			return nil, p.errorf("expected literal, got %q", p.tok.text)
+		}
+		if op != "==" && op != "!=" {
+			return nil, p.errorf("booleans only support == and !=")
+		}
+	default:
+		return nil, p.errorf("expected literal after %q", op)
+	}
+	p.next()
+	return compareNode{attr: attr, op: op, value: value}, p.err
+}
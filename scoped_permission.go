@@ -1,48 +1,82 @@
 package rbac
 
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
 // AddScopedPermission grants a permission to a role with optional scoping.
+// A department scope also covers the department's descendants.
 func (r *RBAC) AddScopedPermission(roleID, permID uint, deptID, targetEmpID *uint) error {
-	if roleID == 0 || permID == 0 {
+	return r.addScopedPermission(&ScopedPermission{
+		RoleID:       roleID,
+		PermissionID: permID,
+		DepartmentID: deptID,
+		EmployeeID:   targetEmpID,
+	})
+}
+
+// AddExactScopedPermission grants a permission to a role in a department
+// only, without covering the department's descendants.
+func (r *RBAC) AddExactScopedPermission(roleID, permID, deptID uint, targetEmpID *uint) error {
+	if deptID == 0 {
+		return ErrInvalidInput
+	}
+	return r.addScopedPermission(&ScopedPermission{
+		RoleID:          roleID,
+		PermissionID:    permID,
+		DepartmentID:    &deptID,
+		EmployeeID:      targetEmpID,
+		ExactDepartment: true,
+	})
+}
+
+// addScopedPermission validates and stores a grant.
+func (r *RBAC) addScopedPermission(scopedPerm *ScopedPermission) error {
+	if scopedPerm.RoleID == 0 || scopedPerm.PermissionID == 0 {
 		return ErrInvalidInput
 	}
 
 	// Validate role and permission
 	var role Role
-	if err := r.db.First(&role, roleID).Error; err != nil {
+	if err := r.db.First(&role, scopedPerm.RoleID).Error; err != nil {
 		return ErrNotFound
 	}
 	var perm Permission
-	if err := r.db.First(&perm, permID).Error; err != nil {
+	if err := r.db.First(&perm, scopedPerm.PermissionID).Error; err != nil {
 		return ErrNotFound
 	}
 
 	// Validate department if provided
-	if deptID != nil {
+	if scopedPerm.DepartmentID != nil {
 		var dept Department
-		if err := r.db.First(&dept, *deptID).Error; err != nil {
+		if err := r.db.First(&dept, *scopedPerm.DepartmentID).Error; err != nil {
 			return ErrNotFound
 		}
 	}
 
-	scopedPerm := &ScopedPermission{
-		RoleID:       roleID,
-		PermissionID: permID,
-		DepartmentID: deptID,
-		EmployeeID:   targetEmpID,
-	}
-
 	if err := r.db.Create(scopedPerm).Error; err != nil {
 		return err
 	}
 
 	r.invalidateCache(0)
 	details := "Granted permission to role"
-	if deptID != nil {
+	if scopedPerm.DepartmentID != nil {
 		details += " in department"
+		if scopedPerm.ExactDepartment {
+			details += " (exact)"
+		}
 	}
-	if targetEmpID != nil {
+	if scopedPerm.EmployeeID != nil {
 		details += " for employee"
 	}
+	if scopedPerm.ScopeKind != "" {
+		details += " relative to caller (" + string(scopedPerm.ScopeKind) + ")"
+	}
+	if scopedPerm.ResourceType != "" {
+		details += " on resource " + ResourceRef{Type: scopedPerm.ResourceType, ID: scopedPerm.ResourceID}.String()
+	}
 	r.logAudit(0, "add_scoped_permission", "scoped_permission", scopedPerm.ID, details)
 	return nil
 }
@@ -143,3 +177,48 @@ func (r *RBAC) ListScopedPermissions(roleID *uint) ([]ScopedPermission, error) {
 	}
 	return scopedPerms, nil
 }
+
+// RestoreScopedPermission undeletes a soft-deleted scoped permission. Its
+// role and permission must not be deleted.
+func (r *RBAC) RestoreScopedPermission(id uint) (*ScopedPermission, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var scopedPerm ScopedPermission
+	if err := r.db.Unscoped().Where("deleted_at IS NOT NULL").First(&scopedPerm, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	var role Role
+	if err := r.db.First(&role, scopedPerm.RoleID).Error; err != nil {
+		return nil, fmt.Errorf("%w: role %d is deleted", ErrInvalidInput, scopedPerm.RoleID)
+	}
+	var perm Permission
+	if err := r.db.First(&perm, scopedPerm.PermissionID).Error; err != nil {
+		return nil, fmt.Errorf("%w: permission %d is deleted", ErrInvalidInput, scopedPerm.PermissionID)
+	}
+
+	if err := r.db.Unscoped().Model(&scopedPerm).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	scopedPerm.DeletedAt = gorm.DeletedAt{}
+
+	r.invalidateCache(0)
+	r.logAudit(0, "restore_scoped_permission", "scoped_permission", id,
+		fmt.Sprintf("Restored grant of %s to role %s", perm.Name, role.Name))
+	return &scopedPerm, nil
+}
+
+// ListDeletedScopedPermissions retrieves soft-deleted scoped permissions,
+// most recently deleted first, optionally filtered by role.
+func (r *RBAC) ListDeletedScopedPermissions(roleID *uint) ([]ScopedPermission, error) {
+	var scopedPerms []ScopedPermission
+	query := r.db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC")
+	if roleID != nil {
+		query = query.Where("role_id = ?", *roleID)
+	}
+	if err := query.Find(&scopedPerms).Error; err != nil {
+		return nil, err
+	}
+	return scopedPerms, nil
+}
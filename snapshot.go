@@ -0,0 +1,316 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// assignmentChange is a change to a direct role assignment.
+type assignmentChange struct {
+	Action     PlanAction
+	Assignment ArchiveAssignment
+}
+
+// planChange describes the change for a plan or audit entry.
+func (c assignmentChange) planChange() PlanChange {
+	a := c.Assignment
+	change := PlanChange{
+		Action: c.Action,
+		Kind:   "assignment",
+		Name:   fmt.Sprintf("employee %d: %s", a.EmployeeID, roleKey{Department: a.Department, Name: a.Role}),
+	}
+	if c.Action != PlanDelete && a.ExpiresAt != nil {
+		change.Details = "until " + a.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return change
+}
+
+// CreateSnapshot records the current departments, permissions, roles,
+// grants, direct role assignments, groups with their members and roles, and
+// separation-of-duties constraints under a unique name. Other records, such
+// as delegations and access requests, are not part of a snapshot.
+func (r *RBAC) CreateSnapshot(name string, actorID uint) (*Snapshot, error) {
+	if name == "" {
+		return nil, ErrInvalidInput
+	}
+
+	var contents *snapshotContents
+	err := r.db.Transaction(func(tx *gorm.DB) (err error) {
+		contents, err = snapshotOf(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{Name: name, Archive: string(data), CreatedBy: actorID}
+	if err := r.db.Create(snapshot).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(actorID, "create_snapshot", "snapshot", snapshot.ID,
+		fmt.Sprintf("Created snapshot %s with %d roles, %d assignments and %d groups",
+			name, len(contents.Policy.Roles), len(contents.Assignments), len(contents.Groups)))
+	return snapshot, nil
+}
+
+// GetSnapshot retrieves a snapshot by ID.
+func (r *RBAC) GetSnapshot(id uint) (*Snapshot, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var snapshot Snapshot
+	if err := r.db.First(&snapshot, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &snapshot, nil
+}
+
+// GetSnapshotByName retrieves a snapshot by name.
+func (r *RBAC) GetSnapshotByName(name string) (*Snapshot, error) {
+	if name == "" {
+		return nil, ErrInvalidInput
+	}
+
+	var snapshot Snapshot
+	if err := r.db.Where("name = ?", name).First(&snapshot).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &snapshot, nil
+}
+
+// ListSnapshots retrieves snapshots, newest first, without their contents.
+func (r *RBAC) ListSnapshots() ([]Snapshot, error) {
+	var snapshots []Snapshot
+	if err := r.db.Omit("archive").Order("created_at DESC").Find(&snapshots).Error; err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot soft-deletes a snapshot.
+func (r *RBAC) DeleteSnapshot(id uint) error {
+	snapshot, err := r.GetSnapshot(id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.Delete(snapshot).Error; err != nil {
+		return err
+	}
+
+	r.logAudit(0, "delete_snapshot", "snapshot", id, "Deleted snapshot "+snapshot.Name)
+	return nil
+}
+
+// snapshotContentsOf decodes a snapshot's contents.
+func (r *RBAC) snapshotContentsOf(id uint) (*snapshotContents, error) {
+	snapshot, err := r.GetSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents snapshotContents
+	if err := json.Unmarshal([]byte(snapshot.Archive), &contents); err != nil {
+		return nil, fmt.Errorf("snapshot %d is corrupt: %w", id, err)
+	}
+	if err := contents.validate(); err != nil {
+		return nil, err
+	}
+	return &contents, nil
+}
+
+// DiffSnapshots lists the changes that turn snapshot a into snapshot b:
+// departments, permissions, roles and grants first, then groups and
+// constraints, then assignments. Changes carry no IDs, as a snapshot's
+// objects need not exist any more; groups are labelled with theirs.
+func (r *RBAC) DiffSnapshots(a, b uint) ([]PlanChange, error) {
+	from, err := r.snapshotContentsOf(a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := r.snapshotContentsOf(b)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffPolicy(policyStateOf(&from.Policy), &to.Policy, PlanOptions{Prune: true})
+	for i := range changes {
+		changes[i].ID = 0
+	}
+	changes = append(changes, diffGroups(from, to)...)
+	for _, c := range diffAssignments(from.Assignments, to.Assignments) {
+		changes = append(changes, c.planChange())
+	}
+	return changes, nil
+}
+
+// RestoreSnapshot returns everything CreateSnapshot records to how it was in
+// the snapshot. Only what differs is changed, in one transaction; every
+// change is audited and all cached decisions are dropped. Departments,
+// permissions, roles, groups and constraints deleted since the snapshot are
+// undeleted; grants come back with new IDs. Assignments that have lapsed
+// since the snapshot are not restored. Restored assignments are checked
+// against role prerequisites and member limits, and restored assignments and
+// group memberships against separation-of-duties constraints, as when they
+// are made by hand; a violation fails the restore. Roles taken away,
+// directly or through groups, follow the PrerequisiteRemoval policy.
+func (r *RBAC) RestoreSnapshot(id, actorID uint) ([]PlanChange, error) {
+	archive, err := r.snapshotContentsOf(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []PlanChange
+	var cascaded []EmployeeRole
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		current, err := exportAssignments(tx)
+		if err != nil {
+			return err
+		}
+		assignChanges := diffAssignments(current, archive.Assignments)
+		holders, err := prerequisiteHolders(tx)
+		if err != nil {
+			return err
+		}
+
+		var affected []uint
+		cascaded, err = r.revokeRoles(tx, holders, func() error {
+			// Remove assignments first, while their roles still exist
+			s, err := loadPolicyState(tx)
+			if err != nil {
+				return err
+			}
+			for _, c := range assignChanges {
+				if c.Action != PlanDelete {
+					continue
+				}
+				roleID := s.roles[roleKey{Department: c.Assignment.Department, Name: c.Assignment.Role}].ID
+				if err := tx.Where("employee_id = ? AND role_id = ?", c.Assignment.EmployeeID, roleID).
+					Delete(&EmployeeRole{}).Error; err != nil {
+					return err
+				}
+				done = append(done, c.planChange())
+			}
+
+			policyChanges, err := applyPolicy(tx, &archive.Policy, PlanOptions{Prune: true})
+			if err != nil {
+				return err
+			}
+			done = append(done, policyChanges...)
+
+			groupChanges, grown, err := restoreGroups(tx, archive)
+			if err != nil {
+				return err
+			}
+			done = append(done, groupChanges...)
+			affected = grown
+
+			if s, err = loadPolicyState(tx); err != nil {
+				return err
+			}
+			grants := make(map[uint][]uint)
+			for _, c := range assignChanges {
+				if c.Action != PlanDelete {
+					roleID := s.roles[roleKey{Department: c.Assignment.Department, Name: c.Assignment.Role}].ID
+					grants[c.Assignment.EmployeeID] = append(grants[c.Assignment.EmployeeID], roleID)
+				}
+			}
+			if len(grants) == 0 {
+				return nil
+			}
+			for empID := range grants {
+				affected = append(affected, empID)
+			}
+			return r.grantRoles(tx, grants, func() error {
+				for _, c := range assignChanges {
+					if c.Action == PlanDelete {
+						continue
+					}
+					roleID := s.roles[roleKey{Department: c.Assignment.Department, Name: c.Assignment.Role}].ID
+					if _, err := putAssignment(tx, c.Assignment.EmployeeID, roleID, c.Assignment.ExpiresAt, true); err != nil {
+						return err
+					}
+					done = append(done, c.planChange())
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		return r.enforceSoD(tx, uniqueIDs(affected))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.invalidateCache(0)
+	r.auditCascade(cascaded)
+	r.auditPolicyChanges(actorID, done)
+	r.logAudit(actorID, "restore_snapshot", "snapshot", id, fmt.Sprintf("Restored snapshot with %d changes", len(done)))
+	return done, nil
+}
+
+// diffAssignments lists the changes that turn one set of assignments into
+// another, ignoring assignments that have lapsed.
+func diffAssignments(from, to []ArchiveAssignment) []assignmentChange {
+	type key struct {
+		EmployeeID uint
+		Role       roleKey
+	}
+	now := time.Now()
+	index := func(assignments []ArchiveAssignment) map[key]ArchiveAssignment {
+		m := make(map[key]ArchiveAssignment, len(assignments))
+		for _, a := range assignments {
+			if a.ExpiresAt == nil || a.ExpiresAt.After(now) {
+				m[key{a.EmployeeID, roleKey{Department: a.Department, Name: a.Role}}] = a
+			}
+		}
+		return m
+	}
+	before, after := index(from), index(to)
+
+	var changes []assignmentChange
+	for k, a := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, assignmentChange{Action: PlanDelete, Assignment: a})
+		}
+	}
+	for k, a := range after {
+		old, ok := before[k]
+		switch {
+		case !ok:
+			changes = append(changes, assignmentChange{Action: PlanCreate, Assignment: a})
+		case !equalTimePtr(old.ExpiresAt, a.ExpiresAt):
+			changes = append(changes, assignmentChange{Action: PlanUpdate, Assignment: a})
+		}
+	}
+
+	order := map[PlanAction]int{PlanDelete: 0, PlanCreate: 1, PlanUpdate: 2}
+	sort.Slice(changes, func(i, j int) bool {
+		ci, cj := changes[i], changes[j]
+		if ci.Action != cj.Action {
+			return order[ci.Action] < order[cj.Action]
+		}
+		if ci.Assignment.EmployeeID != cj.Assignment.EmployeeID {
+			return ci.Assignment.EmployeeID < cj.Assignment.EmployeeID
+		}
+		if ci.Assignment.Department != cj.Assignment.Department {
+			return ci.Assignment.Department < cj.Assignment.Department
+		}
+		return ci.Assignment.Role < cj.Assignment.Role
+	})
+	return changes
+}
@@ -0,0 +1,544 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// snapshotContents is what a snapshot records: an archive, plus the groups
+// and separation-of-duties constraints that archives leave out.
+type snapshotContents struct {
+	Archive
+	Groups         []snapshotGroup      `json:"groups"`
+	SoDConstraints []snapshotConstraint `json:"sod_constraints"`
+}
+
+// snapshotGroup is a group with its direct members and roles. Groups are
+// recorded by ID, as a snapshot is only restored into the database it was
+// taken from and group names need not be unique.
+type snapshotGroup struct {
+	ID      uint             `json:"id"`
+	Name    string           `json:"name"`
+	Rule    string           `json:"rule,omitempty"`
+	Members []snapshotMember `json:"members,omitempty"`
+	Roles   []PolicyRoleRef  `json:"roles,omitempty"`
+}
+
+// snapshotMember is a direct member of a group: an employee or a nested group.
+type snapshotMember struct {
+	EmployeeID    *uint `json:"employee_id,omitempty"`
+	MemberGroupID *uint `json:"member_group_id,omitempty"`
+	Dynamic       bool  `json:"dynamic,omitempty"`
+}
+
+// snapshotConstraint is a separation-of-duties constraint, with its roles
+// by natural key.
+type snapshotConstraint struct {
+	Name        string          `json:"name"`
+	Roles       []PolicyRoleRef `json:"roles,omitempty"`
+	Permissions []string        `json:"permissions,omitempty"`
+}
+
+// snapshotOf reads everything a snapshot records from what tx sees.
+func snapshotOf(tx *gorm.DB) (*snapshotContents, error) {
+	archive, err := archiveOf(tx, ExportOptions{})
+	if err != nil {
+		return nil, err
+	}
+	s, err := loadPolicyState(tx)
+	if err != nil {
+		return nil, err
+	}
+	contents := &snapshotContents{Archive: *archive}
+	if contents.Groups, err = groupsOf(tx, s); err != nil {
+		return nil, err
+	}
+	if contents.SoDConstraints, err = constraintsOf(tx, s); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// roleRef returns a live role's natural key. Roles in deleted departments
+// are left out of snapshots, like in ExportPolicy.
+func (s *policyState) roleRef(roleID uint) (PolicyRoleRef, bool) {
+	key, ok := s.roleKeys[roleID]
+	if !ok {
+		return PolicyRoleRef{}, false
+	}
+	if _, live := s.departments[key.Department]; !live {
+		return PolicyRoleRef{}, false
+	}
+	return PolicyRoleRef{Department: key.Department, Name: key.Name}, true
+}
+
+// groupsOf lists the live groups in ID order, with their members and roles.
+func groupsOf(tx *gorm.DB, s *policyState) ([]snapshotGroup, error) {
+	var groups []Group
+	if err := tx.Order("id").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	var members []GroupMember
+	if err := tx.Order("id").Find(&members).Error; err != nil {
+		return nil, err
+	}
+	var groupRoles []GroupRole
+	if err := tx.Order("id").Find(&groupRoles).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*snapshotGroup, len(groups))
+	result := make([]snapshotGroup, len(groups))
+	for i, g := range groups {
+		result[i] = snapshotGroup{ID: g.ID, Name: g.Name, Rule: g.Rule}
+		byID[g.ID] = &result[i]
+	}
+	for _, m := range members {
+		g, ok := byID[m.GroupID]
+		if !ok || m.MemberGroupID != nil && byID[*m.MemberGroupID] == nil {
+			continue
+		}
+		g.Members = append(g.Members, snapshotMember{EmployeeID: m.EmployeeID, MemberGroupID: m.MemberGroupID, Dynamic: m.Dynamic})
+	}
+	for _, gr := range groupRoles {
+		g, ok := byID[gr.GroupID]
+		ref, live := s.roleRef(gr.RoleID)
+		if !ok || !live {
+			continue
+		}
+		g.Roles = append(g.Roles, ref)
+	}
+	for i := range result {
+		sortRoleRefs(result[i].Roles)
+	}
+	return result, nil
+}
+
+// constraintsOf lists the live separation-of-duties constraints by name.
+func constraintsOf(tx *gorm.DB, s *policyState) ([]snapshotConstraint, error) {
+	var constraints []SoDConstraint
+	if err := tx.Order("name").Find(&constraints).Error; err != nil {
+		return nil, err
+	}
+
+	result := make([]snapshotConstraint, 0, len(constraints))
+	for _, c := range constraints {
+		sc := snapshotConstraint{Name: c.Name, Permissions: append([]string(nil), c.Permissions...)}
+		for _, roleID := range c.RoleIDs {
+			if ref, ok := s.roleRef(roleID); ok {
+				sc.Roles = append(sc.Roles, ref)
+			}
+		}
+		sortRoleRefs(sc.Roles)
+		sort.Strings(sc.Permissions)
+		result = append(result, sc)
+	}
+	return result, nil
+}
+
+// validate checks that the groups and constraints refer only to roles,
+// permissions and groups recorded in the snapshot.
+func (c *snapshotContents) validate() error {
+	if err := c.Archive.validate(); err != nil {
+		return err
+	}
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: snapshot: %s", ErrInvalidInput, fmt.Sprintf(format, args...))
+	}
+
+	roles := make(map[roleKey]bool, len(c.Policy.Roles))
+	for _, role := range c.Policy.Roles {
+		roles[roleKey{Department: role.Department, Name: role.Name}] = true
+	}
+	perms := make(map[string]bool, len(c.Policy.Permissions))
+	for _, perm := range c.Policy.Permissions {
+		perms[perm.Name] = true
+	}
+	groups := make(map[uint]bool, len(c.Groups))
+	for _, g := range c.Groups {
+		if g.ID == 0 || g.Name == "" || groups[g.ID] {
+			return invalid("invalid or duplicate group %d", g.ID)
+		}
+		groups[g.ID] = true
+	}
+	for _, g := range c.Groups {
+		for _, m := range g.Members {
+			if (m.EmployeeID == nil) == (m.MemberGroupID == nil) || m.MemberGroupID != nil && !groups[*m.MemberGroupID] {
+				return invalid("group %d has an invalid member", g.ID)
+			}
+		}
+		for _, ref := range g.Roles {
+			if !roles[ref.key()] {
+				return invalid("group %d holds undeclared role %s", g.ID, ref.key())
+			}
+		}
+	}
+	for _, sc := range c.SoDConstraints {
+		if sc.Name == "" {
+			return invalid("constraint without a name")
+		}
+		for _, ref := range sc.Roles {
+			if !roles[ref.key()] {
+				return invalid("constraint %q names undeclared role %s", sc.Name, ref.key())
+			}
+		}
+		for _, perm := range sc.Permissions {
+			if !perms[perm] {
+				return invalid("constraint %q names undeclared permission %q", sc.Name, perm)
+			}
+		}
+	}
+	return nil
+}
+
+// key returns the natural key of a fully qualified role reference.
+func (ref PolicyRoleRef) key() roleKey {
+	return roleKey{Department: ref.Department, Name: ref.Name}
+}
+
+// sortRoleRefs orders role references by natural key.
+func sortRoleRefs(refs []PolicyRoleRef) {
+	sort.Slice(refs, func(i, j int) bool { return refs[i].key().String() < refs[j].key().String() })
+}
+
+// label names a group in plan changes, e.g. "Auditors #4".
+func (g snapshotGroup) label() string {
+	return fmt.Sprintf("%s #%d", g.Name, g.ID)
+}
+
+// describe names a member in plan changes.
+func (m snapshotMember) describe() string {
+	desc := ""
+	if m.EmployeeID != nil {
+		desc = fmt.Sprintf("employee %d", *m.EmployeeID)
+	} else {
+		desc = fmt.Sprintf("group #%d", *m.MemberGroupID)
+	}
+	if m.Dynamic {
+		desc += " (dynamic)"
+	}
+	return desc
+}
+
+// describe summarises a constraint's contents for plan changes.
+func (sc snapshotConstraint) describe() string {
+	parts := make([]string, 0, len(sc.Roles)+len(sc.Permissions))
+	for _, ref := range sc.Roles {
+		parts = append(parts, ref.key().String())
+	}
+	parts = append(parts, sc.Permissions...)
+	return strings.Join(parts, ", ")
+}
+
+// diffGroups lists the changes to groups, their members and roles, and
+// separation-of-duties constraints that turn one snapshot into another.
+func diffGroups(from, to *snapshotContents) []PlanChange {
+	var changes []PlanChange
+
+	before := make(map[uint]snapshotGroup, len(from.Groups))
+	for _, g := range from.Groups {
+		before[g.ID] = g
+	}
+	after := make(map[uint]bool, len(to.Groups))
+	for _, g := range to.Groups {
+		after[g.ID] = true
+		old, ok := before[g.ID]
+		switch {
+		case !ok:
+			changes = append(changes, PlanChange{Action: PlanCreate, Kind: "group", Name: g.label(), Details: ruleDetails(g.Rule)})
+		case old.Name != g.Name || old.Rule != g.Rule:
+			changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "group", Name: g.label(),
+				Details: fmt.Sprintf("%s -> %s", groupDetails(old), groupDetails(g))})
+		}
+
+		members := make(map[memberKey]bool)
+		for _, m := range old.Members {
+			members[m.key()] = true
+		}
+		for _, m := range g.Members {
+			if !members[m.key()] {
+				changes = append(changes, PlanChange{Action: PlanCreate, Kind: "group_member", Name: g.label() + ": " + m.describe()})
+			}
+			delete(members, m.key())
+		}
+		for _, m := range old.Members {
+			if members[m.key()] {
+				changes = append(changes, PlanChange{Action: PlanDelete, Kind: "group_member", Name: g.label() + ": " + m.describe()})
+			}
+		}
+
+		roles := make(map[roleKey]bool)
+		for _, ref := range old.Roles {
+			roles[ref.key()] = true
+		}
+		for _, ref := range g.Roles {
+			if !roles[ref.key()] {
+				changes = append(changes, PlanChange{Action: PlanCreate, Kind: "group_role", Name: g.label() + ": " + ref.key().String()})
+			}
+			delete(roles, ref.key())
+		}
+		for _, ref := range old.Roles {
+			if roles[ref.key()] {
+				changes = append(changes, PlanChange{Action: PlanDelete, Kind: "group_role", Name: g.label() + ": " + ref.key().String()})
+			}
+		}
+	}
+	for _, g := range from.Groups {
+		if !after[g.ID] {
+			changes = append(changes, PlanChange{Action: PlanDelete, Kind: "group", Name: g.label()})
+		}
+	}
+
+	constraints := make(map[string]snapshotConstraint, len(from.SoDConstraints))
+	for _, sc := range from.SoDConstraints {
+		constraints[sc.Name] = sc
+	}
+	for _, sc := range to.SoDConstraints {
+		old, ok := constraints[sc.Name]
+		switch {
+		case !ok:
+			changes = append(changes, PlanChange{Action: PlanCreate, Kind: "sod_constraint", Name: sc.Name, Details: sc.describe()})
+		case old.describe() != sc.describe():
+			changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "sod_constraint", Name: sc.Name,
+				Details: fmt.Sprintf("%s -> %s", orNone(old.describe()), orNone(sc.describe()))})
+		}
+		delete(constraints, sc.Name)
+	}
+	for _, name := range sortedKeys(constraints) {
+		changes = append(changes, PlanChange{Action: PlanDelete, Kind: "sod_constraint", Name: name})
+	}
+	return changes
+}
+
+// restoreGroups brings groups, their members and roles, and
+// separation-of-duties constraints in line with the snapshot. It must run
+// after the snapshot's policy has been applied, under revokeRoles, as it
+// takes roles away from group members. It returns the changes made and the
+// employees who may have gained roles through groups.
+func restoreGroups(tx *gorm.DB, target *snapshotContents) ([]PlanChange, []uint, error) {
+	s, err := loadPolicyState(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := &snapshotContents{}
+	if current.Groups, err = groupsOf(tx, s); err != nil {
+		return nil, nil, err
+	}
+	if current.SoDConstraints, err = constraintsOf(tx, s); err != nil {
+		return nil, nil, err
+	}
+	changes := diffGroups(current, target)
+
+	// Groups first, so that nested members can refer to them
+	live := make(map[uint]bool, len(current.Groups))
+	for _, g := range current.Groups {
+		live[g.ID] = true
+	}
+	for _, g := range target.Groups {
+		if live[g.ID] {
+			if err := tx.Model(&Group{}).Where("id = ?", g.ID).Updates(map[string]interface{}{"name": g.Name, "rule": g.Rule}).Error; err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		// Revive the deleted group, or recreate a purged one under its old ID
+		res := tx.Unscoped().Model(&Group{}).Where("id = ?", g.ID).
+			Updates(map[string]interface{}{"name": g.Name, "rule": g.Rule, "deleted_at": nil})
+		if res.Error != nil {
+			return nil, nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			if err := tx.Create(&Group{ID: g.ID, Name: g.Name, Rule: g.Rule}).Error; err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	kept := make(map[uint]bool, len(target.Groups))
+	for _, g := range target.Groups {
+		kept[g.ID] = true
+	}
+	for _, g := range current.Groups {
+		if kept[g.ID] {
+			continue
+		}
+		if err := tx.Where("group_id = ? OR member_group_id = ?", g.ID, g.ID).Delete(&GroupMember{}).Error; err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Where("group_id = ?", g.ID).Delete(&GroupRole{}).Error; err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Delete(&Group{}, g.ID).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Members and roles of each group
+	before := make(map[uint]snapshotGroup, len(current.Groups))
+	for _, g := range current.Groups {
+		before[g.ID] = g
+	}
+	var grown []uint // Groups whose members gained roles
+	var added []uint // Employees added to groups
+	for _, g := range target.Groups {
+		old := before[g.ID]
+
+		want := make(map[memberKey]bool, len(g.Members))
+		for _, m := range g.Members {
+			want[m.key()] = true
+		}
+		have := make(map[memberKey]bool, len(old.Members))
+		for _, m := range old.Members {
+			have[m.key()] = true
+			if !want[m.key()] {
+				if err := memberQuery(tx, g.ID, m).Delete(&GroupMember{}).Error; err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		for _, m := range g.Members {
+			if have[m.key()] {
+				continue
+			}
+			member := GroupMember{GroupID: g.ID, EmployeeID: m.EmployeeID, MemberGroupID: m.MemberGroupID, Dynamic: m.Dynamic}
+			if err := tx.Create(&member).Error; err != nil {
+				return nil, nil, err
+			}
+			if m.EmployeeID != nil {
+				added = append(added, *m.EmployeeID)
+			} else {
+				grown = append(grown, *m.MemberGroupID)
+			}
+		}
+
+		roles := make(map[roleKey]bool, len(old.Roles))
+		for _, ref := range old.Roles {
+			roles[ref.key()] = true
+		}
+		for _, ref := range g.Roles {
+			if roles[ref.key()] {
+				delete(roles, ref.key())
+				continue
+			}
+			groupRole := GroupRole{GroupID: g.ID, RoleID: s.roles[ref.key()].ID}
+			if err := tx.Create(&groupRole).Error; err != nil {
+				return nil, nil, err
+			}
+			grown = append(grown, g.ID)
+		}
+		for key := range roles {
+			if err := tx.Where("group_id = ? AND role_id = ?", g.ID, s.roles[key].ID).Delete(&GroupRole{}).Error; err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Constraints, by name
+	existing := make(map[string]bool, len(current.SoDConstraints))
+	for _, sc := range current.SoDConstraints {
+		existing[sc.Name] = true
+	}
+	for _, sc := range target.SoDConstraints {
+		roleIDs := make(IDList, 0, len(sc.Roles))
+		for _, ref := range sc.Roles {
+			roleIDs = append(roleIDs, s.roles[ref.key()].ID)
+		}
+		values := map[string]interface{}{"role_ids": roleIDs, "permissions": StringList(sc.Permissions)}
+		if !existing[sc.Name] {
+			constraint := SoDConstraint{Name: sc.Name, RoleIDs: roleIDs, Permissions: sc.Permissions}
+			if err := createOrRestore(tx, &constraint, "name = ?", sc.Name); err != nil {
+				return nil, nil, err
+			}
+			// A restored constraint keeps its old contents
+			if err := tx.Model(&constraint).Updates(values).Error; err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		delete(existing, sc.Name)
+		if err := tx.Model(&SoDConstraint{}).Where("name = ?", sc.Name).Updates(values).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+	for name := range existing {
+		if err := tx.Where("name = ?", name).Delete(&SoDConstraint{}).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	affected, err := groupEmployeeIDsIn(tx, grown)
+	if err != nil {
+		return nil, nil, err
+	}
+	return changes, uniqueIDs(append(affected, added...)), nil
+}
+
+// memberKey identifies a member by value.
+type memberKey struct {
+	employeeID    uint
+	memberGroupID uint
+	dynamic       bool
+}
+
+// key returns the member's key.
+func (m snapshotMember) key() memberKey {
+	key := memberKey{dynamic: m.Dynamic}
+	if m.EmployeeID != nil {
+		key.employeeID = *m.EmployeeID
+	}
+	if m.MemberGroupID != nil {
+		key.memberGroupID = *m.MemberGroupID
+	}
+	return key
+}
+
+// memberQuery selects the rows recording a member of a group.
+func memberQuery(tx *gorm.DB, groupID uint, m snapshotMember) *gorm.DB {
+	query := tx.Where("group_id = ? AND dynamic = ?", groupID, m.Dynamic)
+	if m.EmployeeID != nil {
+		return query.Where("employee_id = ?", *m.EmployeeID)
+	}
+	return query.Where("member_group_id = ?", *m.MemberGroupID)
+}
+
+// groupEmployeeIDsIn returns the employees in the groups, including members
+// of nested groups, as tx sees them.
+func groupEmployeeIDsIn(tx *gorm.DB, groupIDs []uint) ([]uint, error) {
+	if len(groupIDs) == 0 {
+		return nil, nil
+	}
+	var empIDs []uint
+	err := tx.Raw(`
+		WITH RECURSIVE nested AS (
+			SELECT group_id, 0 AS depth FROM group_members WHERE group_id IN ? AND deleted_at IS NULL
+			UNION
+			SELECT gm.member_group_id, n.depth + 1
+			FROM group_members gm JOIN nested n ON gm.group_id = n.group_id
+			WHERE gm.member_group_id IS NOT NULL AND gm.deleted_at IS NULL AND n.depth < ?
+		)
+		SELECT DISTINCT gm.employee_id FROM group_members gm JOIN nested n ON gm.group_id = n.group_id
+		WHERE gm.employee_id IS NOT NULL AND gm.deleted_at IS NULL`, uniqueIDs(groupIDs), maxHierarchyDepth).
+		Scan(&empIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return empIDs, nil
+}
+
+// ruleDetails describes a new group's rule for a plan change.
+func ruleDetails(rule string) string {
+	if rule == "" {
+		return ""
+	}
+	return "rule " + rule
+}
+
+// groupDetails describes a group's name and rule for a plan change.
+func groupDetails(g snapshotGroup) string {
+	if g.Rule == "" {
+		return g.Name
+	}
+	return g.Name + " with rule " + g.Rule
+}
@@ -0,0 +1,300 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// SoDViolation describes an employee holding more than one item of a
+// separation-of-duties constraint.
+type SoDViolation struct {
+	ConstraintID uint
+	Constraint   string
+	EmployeeID   uint
+	Conflicting  []string // Names of the roles or permissions held together
+}
+
+// SoDViolationError is returned when a change would violate separation of
+// duties. It matches ErrSoDViolation with errors.Is.
+type SoDViolationError struct {
+	Violations []SoDViolation
+}
+
+func (e *SoDViolationError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, fmt.Sprintf("employee %d would hold %s (constraint %q)",
+			v.EmployeeID, strings.Join(v.Conflicting, " and "), v.Constraint))
+	}
+	return ErrSoDViolation.Error() + ": " + strings.Join(parts, "; ")
+}
+
+func (e *SoDViolationError) Unwrap() error {
+	return ErrSoDViolation
+}
+
+// CreateRoleSoDConstraint defines a set of mutually exclusive roles. Existing
+// violations are not removed; use ScanSoDViolations to find them.
+func (r *RBAC) CreateRoleSoDConstraint(name string, roleIDs []uint) (*SoDConstraint, error) {
+	if name == "" || len(roleIDs) < 2 {
+		return nil, ErrInvalidInput
+	}
+
+	var count int64
+	if err := r.db.Model(&Role{}).Where("id IN ?", roleIDs).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(roleIDs) {
+		return nil, ErrNotFound
+	}
+
+	constraint := &SoDConstraint{Name: name, RoleIDs: roleIDs}
+	if err := r.db.Create(constraint).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(0, "create_sod_constraint", "sod_constraint", constraint.ID,
+		fmt.Sprintf("Created SoD constraint %s over roles %s", name, formatIDList(roleIDs)))
+	return constraint, nil
+}
+
+// CreatePermissionSoDConstraint defines permissions that no employee may
+// hold together, through whichever roles.
+func (r *RBAC) CreatePermissionSoDConstraint(name string, permissions []string) (*SoDConstraint, error) {
+	if name == "" || len(permissions) < 2 {
+		return nil, ErrInvalidInput
+	}
+
+	var count int64
+	if err := r.db.Model(&Permission{}).Where("name IN ?", permissions).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(permissions) {
+		return nil, ErrNotFound
+	}
+
+	constraint := &SoDConstraint{Name: name, Permissions: permissions}
+	if err := r.db.Create(constraint).Error; err != nil {
+		return nil, err
+	}
+
+	r.logAudit(0, "create_sod_constraint", "sod_constraint", constraint.ID,
+		fmt.Sprintf("Created SoD constraint %s over permissions %s", name, strings.Join(permissions, ", ")))
+	return constraint, nil
+}
+
+// GetSoDConstraint retrieves a constraint by ID.
+func (r *RBAC) GetSoDConstraint(id uint) (*SoDConstraint, error) {
+	if id == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var constraint SoDConstraint
+	if err := r.db.First(&constraint, id).Error; err != nil {
+		return nil, ErrNotFound
+	}
+
+	return &constraint, nil
+}
+
+// DeleteSoDConstraint soft-deletes a constraint by ID.
+func (r *RBAC) DeleteSoDConstraint(id uint) error {
+	if id == 0 {
+		return ErrInvalidInput
+	}
+
+	var constraint SoDConstraint
+	if err := r.db.First(&constraint, id).Error; err != nil {
+		return ErrNotFound
+	}
+
+	if err := r.db.Delete(&constraint).Error; err != nil {
+		return err
+	}
+
+	r.logAudit(0, "delete_sod_constraint", "sod_constraint", id, "Deleted SoD constraint "+constraint.Name)
+	return nil
+}
+
+// ListSoDConstraints retrieves all constraints.
+func (r *RBAC) ListSoDConstraints() ([]SoDConstraint, error) {
+	var constraints []SoDConstraint
+	if err := r.db.Find(&constraints).Error; err != nil {
+		return nil, err
+	}
+	return constraints, nil
+}
+
+// ScanSoDViolations reports every employee who currently violates a
+// constraint, for example because it was defined after the assignments.
+func (r *RBAC) ScanSoDViolations() ([]SoDViolation, error) {
+	var empIDs []uint
+	err := r.db.Raw(`
+		SELECT employee_id FROM employee_roles WHERE deleted_at IS NULL
+		UNION
+		SELECT employee_id FROM group_members WHERE employee_id IS NOT NULL AND deleted_at IS NULL`).
+		Scan(&empIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return sodViolations(r.db, empIDs)
+}
+
+// enforceSoD fails with a *SoDViolationError if any of the employees
+// violates a constraint. It is run inside the transaction making a change,
+// after the change, so the transaction can be rolled back.
+func (r *RBAC) enforceSoD(db *gorm.DB, empIDs []uint) error {
+	violations, err := sodViolations(db, empIDs)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, v := range violations {
+		r.logAudit(v.EmployeeID, "deny_sod_violation", "sod_constraint", v.ConstraintID,
+			"Blocked change: would hold "+strings.Join(v.Conflicting, " and "))
+	}
+	return &SoDViolationError{Violations: violations}
+}
+
+// sodViolations evaluates every constraint for the employees as the
+// database currently stands.
+func sodViolations(db *gorm.DB, empIDs []uint) ([]SoDViolation, error) {
+	var constraints []SoDConstraint
+	if err := db.Find(&constraints).Error; err != nil {
+		return nil, err
+	}
+	if len(constraints) == 0 || len(empIDs) == 0 {
+		return nil, nil
+	}
+
+	assignments, err := roleAssignmentsIn(db, empIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []SoDViolation
+	roleNames := make(map[uint]string)
+	for _, empID := range empIDs {
+		roleIDs := assignments[empID]
+		if len(roleIDs) == 0 {
+			continue
+		}
+		var held map[uint]bool // Held roles and their ancestors, loaded on first role constraint
+		var permNames []string // Loaded on first permission constraint
+
+		for _, c := range constraints {
+			var conflicting []string
+			if len(c.RoleIDs) > 0 && held == nil {
+				chain, err := roleChainIDs(db, roleIDs)
+				if err != nil {
+					return nil, err
+				}
+				held = make(map[uint]bool, len(chain))
+				for _, id := range chain {
+					held[id] = true
+				}
+			}
+			for _, id := range c.RoleIDs {
+				if held[id] {
+					name, err := roleName(db, roleNames, id)
+					if err != nil {
+						return nil, err
+					}
+					conflicting = append(conflicting, name)
+				}
+			}
+			if len(c.Permissions) > 0 {
+				if permNames == nil {
+					if permNames, err = heldPermissionNames(db, roleIDs); err != nil {
+						return nil, err
+					}
+				}
+				for _, name := range c.Permissions {
+					if containsString(permNames, name) {
+						conflicting = append(conflicting, name)
+					}
+				}
+			}
+			if len(conflicting) > 1 {
+				violations = append(violations, SoDViolation{
+					ConstraintID: c.ID,
+					Constraint:   c.Name,
+					EmployeeID:   empID,
+					Conflicting:  conflicting,
+				})
+			}
+		}
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].EmployeeID != violations[j].EmployeeID {
+			return violations[i].EmployeeID < violations[j].EmployeeID
+		}
+		return violations[i].ConstraintID < violations[j].ConstraintID
+	})
+	return violations, nil
+}
+
+// roleChainIDs returns the roles and their ancestors, so that a role
+// inherited through ParentRoleID counts as held.
+func roleChainIDs(db *gorm.DB, roleIDs []uint) ([]uint, error) {
+	var ids []uint
+	err := db.Raw(`
+		WITH RECURSIVE chain AS (
+			SELECT id, parent_role_id, 0 AS depth
+			FROM roles WHERE id IN ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT p.id, p.parent_role_id, c.depth + 1
+			FROM roles p JOIN chain c ON p.id = c.parent_role_id
+			WHERE p.deleted_at IS NULL AND c.depth < ?
+		)
+		SELECT DISTINCT id FROM chain`,
+		roleIDs, maxHierarchyDepth).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// heldPermissionNames returns the permissions granted, in any scope, to the
+// roles or their ancestors.
+func heldPermissionNames(db *gorm.DB, roleIDs []uint) ([]string, error) {
+	names := []string{}
+	err := db.Raw(`
+		WITH RECURSIVE chain AS (
+			SELECT id, parent_role_id, 0 AS depth
+			FROM roles WHERE id IN ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT p.id, p.parent_role_id, c.depth + 1
+			FROM roles p JOIN chain c ON p.id = c.parent_role_id
+			WHERE p.deleted_at IS NULL AND c.depth < ?
+		)
+		SELECT DISTINCT p.name FROM scoped_permissions sp
+		JOIN permissions p ON p.id = sp.permission_id
+		WHERE sp.role_id IN (SELECT id FROM chain) AND sp.deleted_at IS NULL AND p.deleted_at IS NULL`,
+		roleIDs, maxHierarchyDepth).
+		Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// roleName looks up a role's name through a per-call cache.
+func roleName(db *gorm.DB, cache map[uint]string, id uint) (string, error) {
+	if name, ok := cache[id]; ok {
+		return name, nil
+	}
+	var role Role
+	if err := db.Unscoped().Select("id", "name").First(&role, id).Error; err != nil {
+		return "", err
+	}
+	cache[id] = role.Name
+	return role.Name, nil
+}
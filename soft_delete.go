@@ -0,0 +1,109 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// purgeSpec is a soft-deleted record type PurgeDeleted removes for good.
+// Rows that other remaining rows still refer to are kept, as there are no
+// foreign keys to stop them being orphaned; they are purged by a later run
+// once the rows referring to them are gone.
+type purgeSpec struct {
+	model      interface{}
+	referenced []string // Conditions matching rows that are still referred to
+}
+
+// purgeModels are the soft-deleted records PurgeDeleted removes, in an order
+// that purges referring rows before the rows they refer to. Audit logs,
+// history and records kept for review, such as access requests and
+// certification campaigns, are never purged. Neither are permissions, which
+// CheckPermissionAt needs to resolve names in history.
+var purgeModels = []purgeSpec{
+	{model: &EmployeeRole{}},
+	{model: &ScopedPermission{}},
+	{model: &GroupRole{}},
+	{model: &GroupMember{}},
+	{model: &Group{}, referenced: []string{
+		"EXISTS (SELECT 1 FROM group_members x WHERE x.group_id = groups.id OR x.member_group_id = groups.id)",
+		"EXISTS (SELECT 1 FROM group_roles x WHERE x.group_id = groups.id)",
+	}},
+	{model: &Role{}, referenced: []string{
+		"EXISTS (SELECT 1 FROM employee_roles x WHERE x.role_id = roles.id)",
+		"EXISTS (SELECT 1 FROM scoped_permissions x WHERE x.role_id = roles.id)",
+		"EXISTS (SELECT 1 FROM group_roles x WHERE x.role_id = roles.id)",
+		"EXISTS (SELECT 1 FROM roles x WHERE x.parent_role_id = roles.id)",
+	}},
+	{model: &Department{}, referenced: []string{
+		"EXISTS (SELECT 1 FROM departments x WHERE x.parent_department_id = departments.id)",
+		"EXISTS (SELECT 1 FROM roles x WHERE x.department_id = departments.id)",
+		"EXISTS (SELECT 1 FROM scoped_permissions x WHERE x.department_id = departments.id)",
+		"EXISTS (SELECT 1 FROM employees x WHERE x.department_id = departments.id)",
+	}},
+	{model: &RelationTuple{}},
+	{model: &SoDConstraint{}},
+	{model: &Snapshot{}},
+}
+
+// PurgeDeleted permanently removes records that were soft-deleted more than
+// retention ago, in one transaction, and returns how many rows it removed
+// from each table. Roles, departments and groups that remaining rows still
+// refer to, deleted or not, are kept until those rows are gone. Purged
+// records can no longer be restored, but their history remains for
+// CheckPermissionAt. It is meant to be run periodically.
+func (r *RBAC) PurgeDeleted(retention time.Duration) (map[string]int64, error) {
+	if retention < 0 {
+		return nil, ErrInvalidInput
+	}
+
+	cutoff := time.Now().Add(-retention)
+	purged := make(map[string]int64)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		for _, spec := range purgeModels {
+			query := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+			for _, cond := range spec.referenced {
+				query = query.Not(cond)
+			}
+			res := query.Delete(spec.model)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected > 0 {
+				purged[res.Statement.Table] = res.RowsAffected
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(purged) > 0 {
+		parts := make([]string, 0, len(purged))
+		for _, table := range sortedKeys(purged) {
+			parts = append(parts, fmt.Sprintf("%s: %d", table, purged[table]))
+		}
+		r.logAudit(0, "purge_deleted", "purge", 0,
+			fmt.Sprintf("Purged records deleted before %s (%s)", cutoff.UTC().Format(time.RFC3339), strings.Join(parts, ", ")))
+	}
+	return purged, nil
+}
+
+// migrateNameConstraints drops the table-wide unique constraints on
+// department and permission names, which soft-deleted rows kept hold of, so
+// that AutoMigrate can replace them with unique indexes over live rows only.
+// Constraints may carry GORM's name or PostgreSQL's default one, depending on
+// the GORM version that made them.
+func (r *RBAC) migrateNameConstraints() error {
+	for _, table := range []string{"departments", "permissions"} {
+		for _, constraint := range []string{"uni_" + table + "_name", table + "_name_key"} {
+			if err := r.db.Exec(fmt.Sprintf("ALTER TABLE IF EXISTS %s DROP CONSTRAINT IF EXISTS %s", table, constraint)).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
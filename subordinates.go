@@ -1,55 +1,185 @@
 package rbac
 
-// GetSubordinateIDs fetches IDs of employees whose roles are descendants of the caller's roles.
+import "fmt"
+
+// SubordinateStrategy selects how subordinates and direct reports are derived.
+type SubordinateStrategy string
+
+// Subordinate strategies.
+const (
+	// SubordinatesByRole treats holders of descendant roles as subordinates (default).
+	SubordinatesByRole SubordinateStrategy = "role"
+	// SubordinatesByReportingLine follows Employee.ManagerID.
+	SubordinatesByReportingLine SubordinateStrategy = "reporting_line"
+	// SubordinatesByBoth requires both the role hierarchy and the reporting line to agree.
+	SubordinatesByBoth SubordinateStrategy = "intersection"
+)
+
+// GetSubordinateIDs fetches IDs of the caller's subordinates according to
+// the configured SubordinateStrategy. Under the role strategy these are the
+// employees whose roles are descendants of the caller's roles, with roles
+// held through group membership counting on both sides.
 func (r *RBAC) GetSubordinateIDs(empID uint) ([]uint, error) {
 	if empID == 0 {
 		return nil, ErrInvalidInput
 	}
 
-	// Get employee's roles
-	var empRoles []EmployeeRole
-	if err := r.db.Where("employee_id = ?", empID).Find(&empRoles).Error; err != nil {
-		return nil, err
-	}
-
-	var subordinateRoleIDs []uint
-	for _, empRole := range empRoles {
-		roleIDs, err := r.getDescendantRoleIDs(empRole.RoleID)
+	switch r.subordinateStrategy {
+	case SubordinatesByReportingLine:
+		return r.GetReportIDs(empID, 0)
+	case SubordinatesByBoth:
+		byRole, err := r.roleSubordinateIDs(empID)
 		if err != nil {
 			return nil, err
 		}
-		subordinateRoleIDs = append(subordinateRoleIDs, roleIDs...)
+		byLine, err := r.GetReportIDs(empID, 0)
+		if err != nil {
+			return nil, err
+		}
+		return intersectIDs(byRole, byLine), nil
 	}
+	return r.roleSubordinateIDs(empID)
+}
 
-	// Get employees with these roles
-	var empIDs []uint
-	if err := r.db.Model(&EmployeeRole{}).
-		Where("role_id IN ?", subordinateRoleIDs).
-		Distinct("employee_id").
-		Pluck("employee_id", &empIDs).Error; err != nil {
+// GetDirectReports fetches IDs of the employees whose manager is managerID.
+func (r *RBAC) GetDirectReports(managerID uint) ([]uint, error) {
+	if managerID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var ids []uint
+	if err := r.db.Model(&Employee{}).Where("manager_id = ?", managerID).Order("id").Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetReportIDs fetches IDs of the employees reporting to managerID, directly
+// or through intermediate managers, up to maxDepth levels down (1 returns
+// direct reports only). A maxDepth of zero or less means no limit.
+func (r *RBAC) GetReportIDs(managerID uint, maxDepth int) ([]uint, error) {
+	if managerID == 0 {
+		return nil, ErrInvalidInput
+	}
+	if maxDepth <= 0 || maxDepth > maxHierarchyDepth {
+		maxDepth = maxHierarchyDepth
+	}
+
+	var ids []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE reports AS (
+			SELECT id, 1 AS depth
+			FROM employees WHERE manager_id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT e.id, s.depth + 1
+			FROM employees e JOIN reports s ON e.manager_id = s.id
+			WHERE e.deleted_at IS NULL AND s.depth < ?
+		)
+		SELECT DISTINCT id FROM reports WHERE id <> ? ORDER BY id`, managerID, maxDepth, managerID).
+		Scan(&ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetManagerChainIDs returns the IDs of an employee's managers, nearest first.
+func (r *RBAC) GetManagerChainIDs(empID uint) ([]uint, error) {
+	if empID == 0 {
+		return nil, ErrInvalidInput
+	}
+
+	var ids []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE managers AS (
+			SELECT id, manager_id, 0 AS depth
+			FROM employees WHERE id = ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT e.id, e.manager_id, m.depth + 1
+			FROM employees e JOIN managers m ON e.id = m.manager_id
+			WHERE e.deleted_at IS NULL AND m.depth < ?
+		)
+		SELECT id FROM managers WHERE depth > 0 ORDER BY depth`, empID, maxHierarchyDepth).
+		Scan(&ids).Error
+	if err != nil {
 		return nil, err
 	}
+	return ids, nil
+}
 
-	return empIDs, nil
+// roleSubordinateIDs fetches IDs of employees whose roles are descendants of
+// the caller's roles, including the caller's own roles.
+func (r *RBAC) roleSubordinateIDs(empID uint) ([]uint, error) {
+	// Get employee's roles
+	assignments, err := r.roleAssignments([]uint{empID})
+	if err != nil {
+		return nil, err
+	}
+
+	subordinateRoleIDs, err := r.getDescendantRoleIDs(assignments[empID])
+	if err != nil {
+		return nil, err
+	}
+
+	// Get employees with these roles
+	return r.employeesWithRoles(subordinateRoleIDs)
 }
 
-// getDescendantRoleIDs recursively fetches all descendant role IDs.
-func (r *RBAC) getDescendantRoleIDs(roleID uint) ([]uint, error) {
-	var roleIDs []uint
-	roleIDs = append(roleIDs, roleID)
+// getDescendantRoleIDs fetches the given roles and all their descendant role
+// IDs in a single recursive query.
+func (r *RBAC) getDescendantRoleIDs(roleIDs []uint) ([]uint, error) {
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
 
-	var roles []Role
-	if err := r.db.Where("parent_role_id = ?", roleID).Find(&roles).Error; err != nil {
+	var ids []uint
+	err := r.db.Raw(`
+		WITH RECURSIVE descendants AS (
+			SELECT id, 0 AS depth
+			FROM roles WHERE id IN ? AND deleted_at IS NULL
+			UNION ALL
+			SELECT c.id, d.depth + 1
+			FROM roles c JOIN descendants d ON c.parent_role_id = d.id
+			WHERE c.deleted_at IS NULL AND d.depth < ?
+		)
+		SELECT DISTINCT id FROM descendants`, roleIDs, maxHierarchyDepth).
+		Scan(&ids).Error
+	if err != nil {
 		return nil, err
 	}
+	return ids, nil
+}
 
-	for _, role := range roles {
-		childIDs, err := r.getDescendantRoleIDs(role.ID)
-		if err != nil {
-			return nil, err
+// validateManager rejects a manager assignment that would put an employee in
+// their own reporting line.
+func (r *RBAC) validateManager(empID, managerID uint) error {
+	if managerID == empID {
+		return ErrInvalidInput
+	}
+	chain, err := r.GetManagerChainIDs(managerID)
+	if err != nil {
+		return err
+	}
+	for _, id := range chain {
+		if id == empID {
+			return fmt.Errorf("%w: employee %d already manages employee %d", ErrInvalidInput, empID, managerID)
 		}
-		roleIDs = append(roleIDs, childIDs...)
 	}
+	return nil
+}
 
-	return roleIDs, nil
+// intersectIDs returns the IDs present in both lists, in the order of a.
+func intersectIDs(a, b []uint) []uint {
+	inB := make(map[uint]bool, len(b))
+	for _, id := range b {
+		inB[id] = true
+	}
+	var ids []uint
+	for _, id := range a {
+		if inB[id] {
+			ids = append(ids, id)
+			delete(inB, id)
+		}
+	}
+	return ids
 }